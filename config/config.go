@@ -0,0 +1,520 @@
+// Package config reads the server settings from environment variables.
+package config
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/gommon/bytes"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+// Config holds the settings read from the environment at startup
+type Config struct {
+	Port       int    // PORT, defaults to 1212
+	JWTSecret  string // JWT_SECRET, required
+	BcryptCost int    // BCRYPT_COST, defaults to bcrypt.DefaultCost
+
+	PasswordMinLength int // PASSWORD_MIN_LENGTH, defaults to 8
+
+	RefreshTokenTTL time.Duration // REFRESH_TOKEN_TTL, defaults to 168h (7 days)
+
+	IdempotencyTTL time.Duration // IDEMPOTENCY_TTL, how long /register replays by Idempotency-Key, defaults to 24h
+
+	// REQUIRE_VERIFIED_EMAIL, refuse logins until the email is verified, defaults to false
+	RequireVerifiedEmail bool
+
+	// JSON_SCHEMA_VALIDATION, also check /register bodies against the embedded JSON Schema, defaults to false
+	JSONSchemaValidation bool
+
+	// SOFT_DELETE, DELETE /users/:id only marks the user deleted so it can be restored, defaults to false
+	SoftDelete bool
+
+	DBDriver    string // DB_DRIVER, "memory" (default), "sqlite" or "postgres"
+	DBPath      string // DB_PATH, SQLite database file, defaults to users.db
+	DatabaseURL string // DATABASE_URL, PostgreSQL connection URL, required with DB_DRIVER=postgres
+
+	// Ping the store every STORE_HEALTH_INTERVAL (0 to disable, defaults to 5s) and
+	// refuse API requests after STORE_FAILURE_THRESHOLD failed pings in a row (defaults to 3)
+	StoreHealthInterval   time.Duration
+	StoreFailureThreshold int
+
+	AuditLogFile string // AUDIT_LOG_FILE, JSON lines audit log used with the memory driver, defaults to audit.log
+
+	// Refuse writes with 503 while the service is migrated, reads keep working.
+	// SIGUSR1 switches it at runtime.
+	MaintenanceMode       bool          // MAINTENANCE_MODE, defaults to false
+	MaintenanceMessage    string        // MAINTENANCE_MESSAGE, error message of refused writes
+	MaintenanceRetryAfter time.Duration // MAINTENANCE_RETRY_AFTER, sent as Retry-After, defaults to 60s
+
+	// ALLOWED_ORIGINS, comma-separated origins allowed by CORS, defaults to "*"
+	AllowedOrigins []string
+
+	// TRUSTED_PROXIES, comma-separated CIDRs or IPs of proxies whose X-Forwarded-For is believed.
+	// Empty (the default) uses the connection's address as the client IP.
+	TrustedProxies []*net.IPNet
+
+	// ALLOWED_EMAIL_DOMAINS, comma-separated domains users may register with, in lowercase.
+	// Empty (the default) allows every domain.
+	AllowedEmailDomains []string
+
+	// Per-IP limits on /register and /login
+	RateLimitPerMinute int // RATE_LIMIT_PER_MINUTE, defaults to 5
+	RateLimitBurst     int // RATE_LIMIT_BURST, defaults to 5
+
+	// Account lockout after repeated failed logins
+	LockoutThreshold int           // LOCKOUT_THRESHOLD, defaults to 5
+	LockoutCooldown  time.Duration // LOCKOUT_COOLDOWN, defaults to 15m
+
+	LogLevel slog.Level // LOG_LEVEL, debug, info (default), warn or error
+
+	MetricsEnabled bool // METRICS_ENABLED, serve Prometheus metrics at /metrics, defaults to true
+
+	PrettyJSON bool // PRETTY_JSON, indent JSON responses for debugging, defaults to false
+
+	GzipLevel int // GZIP_LEVEL, gzip compression level from 1 (fastest) to 9 (smallest) or 0 to disable, defaults to 6
+
+	BodyLimit string // BODY_LIMIT, largest accepted request body such as 512K or 1M, defaults to 1M
+
+	AvatarDir string // AVATAR_DIR, directory uploaded profile images are stored in, defaults to avatars
+
+	MaxConcurrentRequests int // MAX_CONCURRENT_REQUESTS, requests served at once before answering 503, 0 for no limit, defaults to 100
+
+	RequestTimeout time.Duration // REQUEST_TIMEOUT, deadline for each request, defaults to 30s
+
+	SlowRequestThreshold time.Duration // SLOW_REQUEST_THRESHOLD, log a warning for slower requests, 0 to disable, defaults to 500ms
+
+	UsersCacheTTL time.Duration // USERS_CACHE_TTL, how long GET /users pages are cached, 0 to disable, defaults to 5s
+
+	// Security headers set on every response
+	ContentSecurityPolicy string // CONTENT_SECURITY_POLICY, defaults to "default-src 'none'; frame-ancestors 'none'"
+	FrameOptions          string // FRAME_OPTIONS, X-Frame-Options value, defaults to DENY
+	HSTSMaxAge            int    // HSTS_MAX_AGE, Strict-Transport-Security max-age in seconds for HTTPS requests, 0 to disable, defaults to 1 year
+
+	// TLS_CERT_FILE and TLS_KEY_FILE, serve HTTPS (and HTTP/2) when both are set
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// WEBHOOK_URL and WEBHOOK_SECRET, POST a signed event there after each registration
+	WebhookURL    string
+	WebhookSecret string
+
+	// OTEL_EXPORTER_OTLP_ENDPOINT, OTLP/HTTP collector such as http://localhost:4318
+	// spans are exported to, tracing is off without it
+	OTLPEndpoint string
+
+	// ADMIN_EMAIL and ADMIN_PASSWORD, create this admin at startup if it doesn't exist yet
+	AdminEmail    string
+	AdminPassword string
+}
+
+// Address the server listens on, e.g. ":1212"
+func (c Config) Addr() string {
+	return ":" + strconv.Itoa(c.Port)
+}
+
+// Whether spans should be recorded and exported
+func (c Config) TracingEnabled() bool {
+	return c.OTLPEndpoint != ""
+}
+
+// Whether the server should serve HTTPS
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// Read and validate the configuration from environment variables
+func Load() (Config, error) {
+	cfg := Config{
+		Port:       1212,
+		JWTSecret:  os.Getenv("JWT_SECRET"),
+		BcryptCost: bcrypt.DefaultCost,
+
+		PasswordMinLength: 8,
+
+		RefreshTokenTTL: 7 * 24 * time.Hour,
+
+		IdempotencyTTL: 24 * time.Hour,
+
+		DBDriver: "memory",
+		DBPath:   "users.db",
+
+		AuditLogFile: "audit.log",
+
+		StoreHealthInterval:   5 * time.Second,
+		StoreFailureThreshold: 3,
+
+		MaintenanceMessage:    "service is under maintenance, try again later",
+		MaintenanceRetryAfter: time.Minute,
+
+		AllowedOrigins: []string{"*"},
+
+		RateLimitPerMinute: 5,
+		RateLimitBurst:     5,
+
+		LockoutThreshold: 5,
+		LockoutCooldown:  15 * time.Minute,
+
+		LogLevel: slog.LevelInfo,
+
+		MetricsEnabled: true,
+
+		GzipLevel: 6,
+
+		BodyLimit: "1M",
+
+		AvatarDir: "avatars",
+
+		MaxConcurrentRequests: 100,
+
+		RequestTimeout: 30 * time.Second,
+
+		SlowRequestThreshold: 500 * time.Millisecond,
+		UsersCacheTTL:        5 * time.Second,
+
+		ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+		FrameOptions:          "DENY",
+		HSTSMaxAge:            365 * 24 * 60 * 60,
+	}
+
+	if v := os.Getenv("PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port < 1 || port > 65535 {
+			return Config{}, fmt.Errorf("invalid PORT %q: must be a number between 1 and 65535", v)
+		}
+		cfg.Port = port
+	}
+
+	if cfg.JWTSecret == "" {
+		return Config{}, errors.New("JWT_SECRET must be set")
+	}
+
+	if v := os.Getenv("BCRYPT_COST"); v != "" {
+		cost, err := strconv.Atoi(v)
+		if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return Config{}, fmt.Errorf("invalid BCRYPT_COST %q: must be a number between %d and %d", v, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		cfg.BcryptCost = cost
+	}
+
+	if v := os.Getenv("PASSWORD_MIN_LENGTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return Config{}, fmt.Errorf("invalid PASSWORD_MIN_LENGTH %q: must be a positive number", v)
+		}
+		cfg.PasswordMinLength = n
+	}
+
+	if v := os.Getenv("REFRESH_TOKEN_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("invalid REFRESH_TOKEN_TTL %q: must be a positive duration such as 168h", v)
+		}
+		cfg.RefreshTokenTTL = d
+	}
+
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("invalid IDEMPOTENCY_TTL %q: must be a positive duration such as 24h", v)
+		}
+		cfg.IdempotencyTTL = d
+	}
+
+	if v := os.Getenv("REQUIRE_VERIFIED_EMAIL"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid REQUIRE_VERIFIED_EMAIL %q: must be true or false", v)
+		}
+		cfg.RequireVerifiedEmail = b
+	}
+
+	if v := os.Getenv("JSON_SCHEMA_VALIDATION"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid JSON_SCHEMA_VALIDATION %q: must be true or false", v)
+		}
+		cfg.JSONSchemaValidation = b
+	}
+
+	if v := os.Getenv("SOFT_DELETE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid SOFT_DELETE %q: must be true or false", v)
+		}
+		cfg.SoftDelete = b
+	}
+
+	if v := os.Getenv("DB_DRIVER"); v != "" {
+		if v != "memory" && v != "sqlite" && v != "postgres" {
+			return Config{}, fmt.Errorf("invalid DB_DRIVER %q: must be memory, sqlite or postgres", v)
+		}
+		cfg.DBDriver = v
+	}
+
+	if v := os.Getenv("DB_PATH"); v != "" {
+		cfg.DBPath = v
+	}
+
+	cfg.DatabaseURL = os.Getenv("DATABASE_URL")
+	if cfg.DBDriver == "postgres" && cfg.DatabaseURL == "" {
+		return Config{}, errors.New("DATABASE_URL must be set when DB_DRIVER is postgres")
+	}
+
+	if v := os.Getenv("AUDIT_LOG_FILE"); v != "" {
+		cfg.AuditLogFile = v
+	}
+
+	if v := os.Getenv("STORE_HEALTH_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return Config{}, fmt.Errorf("invalid STORE_HEALTH_INTERVAL %q: must be a duration such as 5s, 0 to disable", v)
+		}
+		cfg.StoreHealthInterval = d
+	}
+
+	if v := os.Getenv("STORE_FAILURE_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return Config{}, fmt.Errorf("invalid STORE_FAILURE_THRESHOLD %q: must be a positive integer", v)
+		}
+		cfg.StoreFailureThreshold = n
+	}
+
+	if v := os.Getenv("MAINTENANCE_MODE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid MAINTENANCE_MODE %q: must be true or false", v)
+		}
+		cfg.MaintenanceMode = b
+	}
+
+	if v := os.Getenv("MAINTENANCE_MESSAGE"); v != "" {
+		cfg.MaintenanceMessage = v
+	}
+
+	if v := os.Getenv("MAINTENANCE_RETRY_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return Config{}, fmt.Errorf("invalid MAINTENANCE_RETRY_AFTER %q: must be a duration of at least 1s", v)
+		}
+		cfg.MaintenanceRetryAfter = d
+	}
+
+	if v := os.Getenv("ALLOWED_ORIGINS"); v != "" {
+		cfg.AllowedOrigins = splitList(v)
+	}
+
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		for _, entry := range splitList(v) {
+			if !strings.Contains(entry, "/") {
+				if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+					entry += "/32"
+				} else {
+					entry += "/128"
+				}
+			}
+			_, ipNet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: must be an IP or CIDR such as 10.0.0.0/8", entry)
+			}
+			cfg.TrustedProxies = append(cfg.TrustedProxies, ipNet)
+		}
+	}
+
+	if v := os.Getenv("ALLOWED_EMAIL_DOMAINS"); v != "" {
+		for _, domain := range splitList(v) {
+			cfg.AllowedEmailDomains = append(cfg.AllowedEmailDomains, strings.ToLower(strings.TrimPrefix(domain, "@")))
+		}
+	}
+
+	if v := os.Getenv("RATE_LIMIT_PER_MINUTE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return Config{}, fmt.Errorf("invalid RATE_LIMIT_PER_MINUTE %q: must be a positive number", v)
+		}
+		cfg.RateLimitPerMinute = n
+	}
+
+	if v := os.Getenv("RATE_LIMIT_BURST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return Config{}, fmt.Errorf("invalid RATE_LIMIT_BURST %q: must be a positive number", v)
+		}
+		cfg.RateLimitBurst = n
+	}
+
+	if v := os.Getenv("LOCKOUT_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return Config{}, fmt.Errorf("invalid LOCKOUT_THRESHOLD %q: must be a positive number", v)
+		}
+		cfg.LockoutThreshold = n
+	}
+
+	if v := os.Getenv("LOCKOUT_COOLDOWN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("invalid LOCKOUT_COOLDOWN %q: must be a positive duration such as 15m", v)
+		}
+		cfg.LockoutCooldown = d
+	}
+
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := cfg.LogLevel.UnmarshalText([]byte(v)); err != nil {
+			return Config{}, fmt.Errorf("invalid LOG_LEVEL %q: must be debug, info, warn or error", v)
+		}
+	}
+
+	if v := os.Getenv("METRICS_ENABLED"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid METRICS_ENABLED %q: must be true or false", v)
+		}
+		cfg.MetricsEnabled = b
+	}
+
+	if v := os.Getenv("PRETTY_JSON"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid PRETTY_JSON %q: must be true or false", v)
+		}
+		cfg.PrettyJSON = b
+	}
+
+	if v := os.Getenv("GZIP_LEVEL"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > gzip.BestCompression {
+			return Config{}, fmt.Errorf("invalid GZIP_LEVEL %q: must be between 0 and 9", v)
+		}
+		cfg.GzipLevel = n
+	}
+
+	if v := os.Getenv("BODY_LIMIT"); v != "" {
+		if n, err := bytes.Parse(v); err != nil || n <= 0 {
+			return Config{}, fmt.Errorf("invalid BODY_LIMIT %q: must be a size such as 512K or 1M", v)
+		}
+		cfg.BodyLimit = v
+	}
+
+	if v := os.Getenv("AVATAR_DIR"); v != "" {
+		cfg.AvatarDir = v
+	}
+
+	if v := os.Getenv("MAX_CONCURRENT_REQUESTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return Config{}, fmt.Errorf("invalid MAX_CONCURRENT_REQUESTS %q: must be a number of 0 or more", v)
+		}
+		cfg.MaxConcurrentRequests = n
+	}
+
+	if v := os.Getenv("REQUEST_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("invalid REQUEST_TIMEOUT %q: must be a positive duration such as 30s", v)
+		}
+		cfg.RequestTimeout = d
+	}
+
+	if v := os.Getenv("SLOW_REQUEST_THRESHOLD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return Config{}, fmt.Errorf("invalid SLOW_REQUEST_THRESHOLD %q: must be a duration such as 500ms, 0 to disable", v)
+		}
+		cfg.SlowRequestThreshold = d
+	}
+
+	if v := os.Getenv("USERS_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return Config{}, fmt.Errorf("invalid USERS_CACHE_TTL %q: must be a duration such as 5s, 0 to disable", v)
+		}
+		cfg.UsersCacheTTL = d
+	}
+
+	if v := os.Getenv("CONTENT_SECURITY_POLICY"); v != "" {
+		cfg.ContentSecurityPolicy = v
+	}
+
+	if v := os.Getenv("FRAME_OPTIONS"); v != "" {
+		if v != "DENY" && v != "SAMEORIGIN" {
+			return Config{}, fmt.Errorf("invalid FRAME_OPTIONS %q: must be DENY or SAMEORIGIN", v)
+		}
+		cfg.FrameOptions = v
+	}
+
+	if v := os.Getenv("HSTS_MAX_AGE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return Config{}, fmt.Errorf("invalid HSTS_MAX_AGE %q: must be a number of seconds, 0 to disable", v)
+		}
+		cfg.HSTSMaxAge = n
+	}
+
+	cfg.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	cfg.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return Config{}, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	for _, file := range []string{cfg.TLSCertFile, cfg.TLSKeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			return Config{}, fmt.Errorf("TLS file %q: %w", file, err)
+		}
+	}
+
+	cfg.WebhookURL = os.Getenv("WEBHOOK_URL")
+	cfg.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+	if cfg.WebhookURL != "" {
+		u, err := url.Parse(cfg.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Config{}, fmt.Errorf("invalid WEBHOOK_URL %q: must be an http or https URL", cfg.WebhookURL)
+		}
+		if cfg.WebhookSecret == "" {
+			return Config{}, errors.New("WEBHOOK_SECRET must be set when WEBHOOK_URL is")
+		}
+	}
+
+	cfg.OTLPEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if cfg.OTLPEndpoint != "" {
+		u, err := url.Parse(cfg.OTLPEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Config{}, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_ENDPOINT %q: must be an http or https URL", cfg.OTLPEndpoint)
+		}
+	}
+
+	cfg.AdminEmail = os.Getenv("ADMIN_EMAIL")
+	cfg.AdminPassword = os.Getenv("ADMIN_PASSWORD")
+	if (cfg.AdminEmail == "") != (cfg.AdminPassword == "") {
+		return Config{}, errors.New("ADMIN_EMAIL and ADMIN_PASSWORD must be set together")
+	}
+	if cfg.AdminEmail != "" && !model.IsValidEmail(cfg.AdminEmail) {
+		return Config{}, fmt.Errorf("invalid ADMIN_EMAIL %q", cfg.AdminEmail)
+	}
+
+	return cfg, nil
+}
+
+// Split a comma-separated list, dropping blank entries
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
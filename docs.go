@@ -0,0 +1,33 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Hand-maintained OpenAPI 3 description of the API, update it with the routes
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Swagger UI page rendering openAPISpec
+//
+//go:embed docs.html
+var docsPage []byte
+
+// Swagger UI is loaded from unpkg and started by an inline script,
+// which the API's default Content-Security-Policy would block
+const docsPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline' https://unpkg.com; style-src 'self' 'unsafe-inline' https://unpkg.com; img-src 'self' data:; frame-ancestors 'none'"
+
+// Serve the OpenAPI document at /openapi.json and Swagger UI at /docs
+func registerDocs(e *echo.Echo) {
+	e.GET("/openapi.json", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, openAPISpec)
+	})
+	e.GET("/docs", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentSecurityPolicy, docsPolicy)
+		return c.HTMLBlob(http.StatusOK, docsPage)
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/melisacar/go-rest-api.git/config"
+	"github.com/melisacar/go-rest-api.git/handler"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Echo path parameters such as :id, written {id} in the spec
+var pathParam = regexp.MustCompile(`:(\w+)`)
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	var spec struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json isn't valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("openapi = %q, want a 3.x document", spec.OpenAPI)
+	}
+
+	auditLog, err := store.NewFileAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+	h := handler.New(store.NewMemoryUserStore(), auditLog, config.Config{BcryptCost: bcrypt.MinCost}, handler.BuildInfo{})
+	e := echo.New()
+	handler.RegisterRoutes(e, h)
+
+	// The spec's server is /api/v1, the probes and /version are listed at the root
+	for _, route := range e.Routes() {
+		path := pathParam.ReplaceAllString(strings.TrimPrefix(route.Path, "/api/v1"), "{$1}")
+		if _, ok := spec.Paths[path][strings.ToLower(route.Method)]; !ok {
+			t.Errorf("%s %s isn't documented in openapi.json", route.Method, route.Path)
+		}
+	}
+}
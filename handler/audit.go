@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Actors recorded when no user token is involved
+const (
+	actorAnonymous = "anonymous"
+	actorSystem    = "system"
+)
+
+// The id of the user whose token authenticated the request, or anonymous
+func actorOf(c echo.Context) string {
+	if userID, _ := c.Get(userIDKey).(string); userID != "" {
+		return userID
+	}
+	return actorAnonymous
+}
+
+// Record a write in the audit log. The write has already happened,
+// so failing to record it is logged rather than failing the request.
+func (h *Handler) audit(ctx context.Context, logger *slog.Logger, actor, action, targetID string) {
+	err := h.auditLog.Append(ctx, store.AuditEntry{Actor: actor, Action: action, TargetID: targetID})
+	if err != nil {
+		logger.Error("could not write audit entry", "action", action, "target_id", targetID, "error", err)
+	}
+}
+
+// Record a write made by the request's user
+func (h *Handler) auditRequest(c echo.Context, action, targetID string) {
+	h.audit(c.Request().Context(), requestLog(c), actorOf(c), action, targetID)
+}
+
+// Page through the audit log newest first, optionally only the entries
+// with ?action= and ?actor=
+func (h *Handler) ListAudit(c echo.Context) error {
+	page, limit := parsePagination(c)
+	entries, total, err := h.auditLog.List(c.Request().Context(), c.QueryParam("action"), c.QueryParam("actor"), (page-1)*limit, limit)
+	if err != nil {
+		return respondStoreError(c, err, "Could not list audit entries")
+	}
+
+	setPageLinks(c, page, limit, total)
+	return respondJSON(c, http.StatusOK, map[string]interface{}{
+		"data":  entries,
+		"page":  page,
+		"limit": limit,
+		"total": total,
+	})
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Entries of GET /audit
+type auditPage struct {
+	Data  []store.AuditEntry `json:"data"`
+	Total int                `json:"total"`
+}
+
+// Fetch the audit log as admin
+func (s *testServer) listAudit(t *testing.T, token, query string) auditPage {
+	t.Helper()
+	rec := s.request(http.MethodGet, "/api/v1/audit"+query, "", token)
+	expectStatus(t, rec, http.StatusOK)
+	if body := rec.Body.String(); strings.Contains(body, "abc12345") || strings.Contains(body, "$2a$") {
+		t.Errorf("audit log leaks a password or hash: %s", body)
+	}
+	var page auditPage
+	decode(t, rec, &page)
+	return page
+}
+
+func TestAuditLog(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	admin := s.adminToken(t)
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	expectStatus(t, s.request(http.MethodDelete, "/api/v1/users/"+user.ID, "", admin), http.StatusNoContent)
+	adminUser, err := s.users.GetByEmail(context.Background(), "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Newest first, one entry per write
+	page := s.listAudit(t, admin, "")
+	if page.Total != 2 || len(page.Data) != 2 {
+		t.Fatalf("audit log = %+v, want 2 entries", page)
+	}
+	deleted, created := page.Data[0], page.Data[1]
+	if created.Action != store.AuditUserCreate || created.Actor != actorAnonymous || created.TargetID != user.ID || created.CreatedAt.IsZero() {
+		t.Errorf("registration entry = %+v", created)
+	}
+	if deleted.Action != store.AuditUserDelete || deleted.Actor != adminUser.ID || deleted.TargetID != user.ID || deleted.CreatedAt.IsZero() {
+		t.Errorf("deletion entry = %+v", deleted)
+	}
+
+	if page := s.listAudit(t, admin, "?action="+store.AuditUserDelete); page.Total != 1 || page.Data[0].ID != deleted.ID {
+		t.Errorf("filtered by action = %+v", page)
+	}
+	if page := s.listAudit(t, admin, "?actor="+actorAnonymous); page.Total != 1 || page.Data[0].ID != created.ID {
+		t.Errorf("filtered by actor = %+v", page)
+	}
+
+	s.register(t, "Ada", "ada@example.com", "abc12345")
+	token := s.login(t, "ada@example.com", "abc12345")
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/audit", "", token), http.StatusForbidden)
+}
+
+// An audit log whose listing fails
+type failingAuditLog struct {
+	store.AuditLog
+}
+
+func (failingAuditLog) List(ctx context.Context, action, actor string, offset, limit int) ([]store.AuditEntry, int, error) {
+	return nil, 0, errStoreDown
+}
+
+func TestListAuditFailureIsNotEmptyPage(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	admin := s.adminToken(t)
+	s.auditLog = failingAuditLog{AuditLog: s.auditLog}
+
+	rec := s.request(http.MethodGet, "/api/v1/audit", "", admin)
+	expectStatus(t, rec, http.StatusInternalServerError)
+	if strings.Contains(rec.Body.String(), `"data"`) {
+		t.Errorf("failure answered with a page: %s", rec.Body)
+	}
+}
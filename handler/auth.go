@@ -0,0 +1,452 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	// https://pkg.go.dev/github.com/golang-jwt/jwt/v5
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Body of /me, the public user plus when they last logged in so they can spot logins that weren't theirs
+type MeResponse struct {
+	model.UserResponse
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// Login request body
+type LoginRequest struct {
+	Email    string `json:"email" validate:"required"`
+	Password string `json:"password" validate:"required" trim:"-"`
+}
+
+// Body of /token/refresh and /logout
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Password reset request body
+type ResetRequest struct {
+	Email string `json:"email" validate:"required"`
+}
+
+// Password reset body, the token comes from /password/reset-request
+type PasswordReset struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,password" trim:"-"`
+}
+
+// Password change body for a logged in user
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password" validate:"required" trim:"-"`
+	NewPassword     string `json:"new_password" validate:"required,password" trim:"-"`
+}
+
+// Register endpoint
+func (h *Handler) Register(c echo.Context) error {
+	return h.register(c, false)
+}
+
+// Check a registration form without creating the user. It answers with the
+// same errors as /register, or 200 {"valid": true} when registering would succeed.
+func (h *Handler) ValidateRegistration(c echo.Context) error {
+	return h.register(c, true)
+}
+
+// Shared by both endpoints so a dry run can't drift from the real thing
+func (h *Handler) register(c echo.Context, dryRun bool) error {
+
+	// Initialize a User struct to bind incoming data
+	var user model.User // Creates a variable user of type User
+
+	// Bind JSON body to the struct
+	if err := c.Bind(&user); err != nil {
+		return respondBindError(c, err)
+	}
+
+	// Validate all fields using the struct tags
+	if err := c.Validate(&user); err != nil {
+		return h.respondValidationError(c, err)
+	}
+
+	// Only accept the configured domains
+	if !h.emailDomainAllowed(user.Email) {
+		return respondFieldError(c, "email", "domain "+emailDomain(user.Email)+" is not allowed")
+	}
+
+	// Stop before anything is stored, the email must still be free. Soft-deleted
+	// users keep theirs, so this asks the same question Create does.
+	if dryRun {
+		taken, err := h.store.EmailTaken(c.Request().Context(), user.Email)
+		if err != nil {
+			return respondStoreError(c, err, "Could not check registration")
+		}
+		if taken {
+			return respondError(c, http.StatusConflict, "email_exists", "email already registered")
+		}
+		return respondJSON(c, http.StatusOK, map[string]bool{"valid": true})
+	}
+
+	// Store the user so they can log in
+	user, err := h.createUser(c.Request().Context(), user)
+	if err != nil {
+		if errors.Is(err, store.ErrEmailExists) {
+			return respondError(c, http.StatusConflict, "email_exists", "email already registered")
+		}
+		return respondStoreError(c, err, "Could not register user")
+	}
+
+	// Record the signup and tell the webhook receiver, in the background
+	h.auditRequest(c, store.AuditUserCreate, user.ID)
+	h.webhook.Send(requestLog(c), eventUserRegistered, user)
+
+	// Token for GET /verify, returned until verification emails are sent
+	verificationToken, err := h.generatePurposeToken(user, purposeVerify, verifyTokenTTL)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "internal_error", "Could not register user")
+	}
+
+	// Return success response
+	return respondJSON(c, http.StatusOK, map[string]interface{}{
+		"message":            "User registered successfully",
+		"user":               model.NewUserResponse(user),
+		"verification_token": verificationToken,
+	})
+}
+
+// Email verification endpoint, takes the token returned by /register
+func (h *Handler) Verify(c echo.Context) error {
+	claims, err := h.parsePurposeToken(c.QueryParam("token"), purposeVerify)
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return respondError(c, http.StatusBadRequest, "token_expired", "verification token expired")
+	case err != nil:
+		return respondError(c, http.StatusBadRequest, "invalid_token", "invalid verification token")
+	}
+
+	if err := h.store.SetVerified(c.Request().Context(), claims.Subject); err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+		}
+		return respondStoreError(c, err, "Could not verify email")
+	}
+	h.audit(c.Request().Context(), requestLog(c), claims.Subject, store.AuditUserVerify, claims.Subject)
+
+	return respondJSON(c, http.StatusOK, map[string]string{
+		"message": "Email verified successfully",
+	})
+}
+
+// Login endpoint
+func (h *Handler) Login(c echo.Context) error {
+
+	// Bind and validate the credentials
+	var req LoginRequest
+	if err := c.Bind(&req); err != nil {
+		return respondBindError(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return h.respondValidationError(c, err)
+	}
+
+	// Refuse locked accounts, even with the right password
+	if h.lockout.Locked(req.Email) {
+		return respondError(c, http.StatusLocked, "account_locked", "account locked, try again later")
+	}
+
+	// Every rejected attempt counts towards the lockout, even when it shared its
+	// check with others. One the store couldn't answer isn't held against the account.
+	user, err := h.checkLogin(c.Request().Context(), req.Email, req.Password)
+	switch {
+	case errors.Is(err, errInvalidCredentials):
+		h.lockout.Fail(req.Email)
+		return respondError(c, http.StatusUnauthorized, "invalid_credentials", "invalid credentials")
+	case err != nil:
+		return respondStoreError(c, err, "Could not log in")
+	}
+	h.lockout.Reset(req.Email)
+
+	// Bring hashes made before BCRYPT_COST was raised up to the current cost
+	if h.needsRehash(user.PasswordHash) {
+		h.rehashPassword(c, user, req.Password)
+	}
+
+	// Optionally refuse users who haven't verified their email yet
+	if h.cfg.RequireVerifiedEmail && !user.Verified {
+		return respondError(c, http.StatusForbidden, "email_not_verified", "email not verified")
+	}
+
+	// Remember the login for /me, failing to only loses the timestamp
+	if err := h.store.SetLastLogin(c.Request().Context(), user.ID, time.Now()); err != nil {
+		requestLog(c).Warn("could not record last login", "user_id", user.ID, "error", err)
+	}
+
+	// Issue an access token and a refresh token for the user
+	token, err := h.generateToken(user)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "internal_error", "Could not create token")
+	}
+	refreshToken, err := h.refreshTokens.Issue(user.ID)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "internal_error", "Could not create token")
+	}
+
+	// Return success response
+	return respondJSON(c, http.StatusOK, map[string]interface{}{
+		"token":         token,
+		"expires_in":    int(tokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+	})
+}
+
+// Returned by checkLogin for an unknown email or a wrong password alike
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// Look up the user and check their password, errInvalidCredentials when either
+// fails and the store's error when the user couldn't be looked up. Concurrent
+// attempts with the same email and password share one lookup and bcrypt
+// comparison. Only attempts in flight are shared, so a result is never reused
+// by a later attempt. Each attempt still gives up at its own deadline.
+func (h *Handler) checkLogin(ctx context.Context, email, password string) (model.User, error) {
+	// Hash the password so it isn't kept in the key
+	sum := sha256.Sum256([]byte(password))
+	key := store.NormalizeEmail(email) + "\x00" + hex.EncodeToString(sum[:])
+
+	results := h.logins.DoChan(key, func() (interface{}, error) {
+		// The first attempt going away mustn't fail the ones waiting on it,
+		// but the shared check is held to REQUEST_TIMEOUT all the same
+		ctx := context.WithoutCancel(ctx)
+		if h.cfg.RequestTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, h.cfg.RequestTimeout)
+			defer cancel()
+		}
+
+		user, err := h.store.GetByEmail(ctx, email)
+		if err != nil && !errors.Is(err, store.ErrUserNotFound) {
+			return nil, err
+		}
+
+		// Compare against a dummy hash when the user is unknown,
+		// so both failure cases take about the same time
+		hash := h.dummyHash
+		if err == nil {
+			hash = user.PasswordHash
+		}
+		if !checkPassword(ctx, hash, password) || err != nil {
+			return nil, errInvalidCredentials
+		}
+		return user, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return model.User{}, ctx.Err()
+	case result := <-results:
+		if result.Err != nil {
+			return model.User{}, result.Err
+		}
+		return result.Val.(model.User), nil
+	}
+}
+
+// Exchange a refresh token for a new access token, the refresh token is rotated
+func (h *Handler) RefreshToken(c echo.Context) error {
+	var req RefreshRequest
+	if err := c.Bind(&req); err != nil {
+		return respondBindError(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return h.respondValidationError(c, err)
+	}
+
+	userID, refreshToken, err := h.refreshTokens.Rotate(req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			return respondError(c, http.StatusUnauthorized, "invalid_token", "invalid refresh token")
+		}
+		return respondError(c, http.StatusInternalServerError, "internal_error", "Could not create token")
+	}
+
+	// The user may have been deleted since logging in
+	user, err := h.store.GetByID(c.Request().Context(), userID)
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		h.refreshTokens.Revoke(refreshToken)
+		return respondError(c, http.StatusUnauthorized, "invalid_token", "invalid refresh token")
+	case err != nil:
+		h.refreshTokens.Revoke(refreshToken)
+		return respondStoreError(c, err, "Could not create token")
+	}
+	token, err := h.generateToken(user)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "internal_error", "Could not create token")
+	}
+
+	return respondJSON(c, http.StatusOK, map[string]interface{}{
+		"token":         token,
+		"expires_in":    int(tokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+	})
+}
+
+// Revoke a refresh token, ending the session it belongs to
+func (h *Handler) Logout(c echo.Context) error {
+	var req RefreshRequest
+	if err := c.Bind(&req); err != nil {
+		return respondBindError(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return h.respondValidationError(c, err)
+	}
+
+	h.refreshTokens.Revoke(req.RefreshToken)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Start a password reset. The response is the same whether or not the
+// email is registered, so it can't be used to find accounts.
+func (h *Handler) RequestPasswordReset(c echo.Context) error {
+	var req ResetRequest
+	if err := c.Bind(&req); err != nil {
+		return respondBindError(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return h.respondValidationError(c, err)
+	}
+
+	user, err := h.store.GetByEmail(c.Request().Context(), req.Email)
+	switch {
+	case err == nil:
+		token, err := h.resetTokens.Issue(user.ID)
+		if err != nil {
+			return respondError(c, http.StatusInternalServerError, "internal_error", "Could not start password reset")
+		}
+		// The token is as good as the password, so it is never logged
+		if h.resetSender == nil {
+			requestLog(c).Warn("password reset requested but no reset sender is set", "user_id", user.ID)
+			break
+		}
+		h.resetSender(user, token)
+	case !errors.Is(err, store.ErrUserNotFound):
+		return respondStoreError(c, err, "Could not start password reset")
+	}
+
+	return respondJSON(c, http.StatusOK, map[string]string{
+		"message": "If the email is registered, reset instructions have been sent",
+	})
+}
+
+// Finish a password reset with the token and a new password
+func (h *Handler) ResetPassword(c echo.Context) error {
+	var req PasswordReset
+	if err := c.Bind(&req); err != nil {
+		return respondBindError(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return h.respondValidationError(c, err)
+	}
+
+	// Check the token before using it up, so a rejected password can be retried
+	userID, ok := h.resetTokens.Lookup(req.Token)
+	if !ok {
+		return respondError(c, http.StatusBadRequest, "invalid_token", "invalid or expired reset token")
+	}
+	user, err := h.store.GetByID(c.Request().Context(), userID)
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		return respondError(c, http.StatusBadRequest, "invalid_token", "invalid or expired reset token")
+	case err != nil:
+		return respondStoreError(c, err, "Could not reset password")
+	}
+	if err := checkPasswordPolicy(req.NewPassword, user.Email, h.cfg.PasswordMinLength); err != nil {
+		return respondFieldError(c, "new_password", err.Error())
+	}
+
+	hash, err := h.hashPassword(c.Request().Context(), req.NewPassword)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "internal_error", "Could not reset password")
+	}
+	if !h.resetTokens.Consume(req.Token) {
+		return respondError(c, http.StatusBadRequest, "invalid_token", "invalid or expired reset token")
+	}
+	if err := h.store.UpdatePassword(c.Request().Context(), user.ID, hash); err != nil {
+		return respondStoreError(c, err, "Could not reset password")
+	}
+	h.audit(c.Request().Context(), requestLog(c), user.ID, store.AuditUserPasswordReset, user.ID)
+
+	// Whoever knew the old password may still hold a session
+	h.refreshTokens.RevokeUser(user.ID)
+
+	return respondJSON(c, http.StatusOK, map[string]string{
+		"message": "Password reset successfully",
+	})
+}
+
+// Change the current user's password, requires a valid access token
+func (h *Handler) ChangePassword(c echo.Context) error {
+	var req PasswordChange
+	if err := c.Bind(&req); err != nil {
+		return respondBindError(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return h.respondValidationError(c, err)
+	}
+
+	user, err := h.store.GetByID(c.Request().Context(), c.Get(userIDKey).(string))
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+	case err != nil:
+		return respondStoreError(c, err, "Could not load user")
+	}
+	if !checkPassword(c.Request().Context(), user.PasswordHash, req.CurrentPassword) {
+		return respondError(c, http.StatusUnauthorized, "invalid_credentials", "current password is incorrect")
+	}
+	if req.NewPassword == req.CurrentPassword {
+		return respondFieldError(c, "new_password", "must differ from the current password")
+	}
+	if err := checkPasswordPolicy(req.NewPassword, user.Email, h.cfg.PasswordMinLength); err != nil {
+		return respondFieldError(c, "new_password", err.Error())
+	}
+
+	hash, err := h.hashPassword(c.Request().Context(), req.NewPassword)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "internal_error", "Could not change password")
+	}
+	if err := h.store.UpdatePassword(c.Request().Context(), user.ID, hash); err != nil {
+		return respondStoreError(c, err, "Could not change password")
+	}
+	h.auditRequest(c, store.AuditUserPasswordChange, user.ID)
+
+	// Sessions started with the old password end, this one included, so log in again for a new refresh token
+	h.refreshTokens.RevokeUser(user.ID)
+
+	return respondJSON(c, http.StatusOK, map[string]string{
+		"message": "Password changed successfully",
+	})
+}
+
+// Current user endpoint, requires a valid access token
+func (h *Handler) Me(c echo.Context) error {
+	user, err := h.store.GetByID(c.Request().Context(), c.Get(userIDKey).(string))
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+	case err != nil:
+		return respondStoreError(c, err, "Could not load user")
+	}
+
+	return respondJSON(c, http.StatusOK, MeResponse{
+		UserResponse: model.NewUserResponse(user),
+		LastLoginAt:  user.LastLoginAt,
+	})
+}
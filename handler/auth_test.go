@@ -0,0 +1,490 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+func TestRegisterDuplicateEmail(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	s.register(t, "Melisa", "Melisa@Example.com", "abc12345")
+
+	for _, email := range []string{"Melisa@Example.com", "melisa@example.com"} {
+		rec := s.request(http.MethodPost, "/api/v1/register", `{"name":"Melisa","email":"`+email+`","password":"abc12345"}`, "")
+		expectStatus(t, rec, http.StatusConflict)
+		var body APIError
+		decode(t, rec, &body)
+		if body.Message != "email already registered" {
+			t.Errorf("%s: error = %q, want %q", email, body.Message, "email already registered")
+		}
+	}
+
+	// The email is stored lowercased and found that way
+	user, err := s.users.GetByEmail(context.Background(), "melisa@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "melisa@example.com" {
+		t.Errorf("stored email = %q, want it lowercased", user.Email)
+	}
+}
+
+func TestRegisterVerifyLogin(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequireVerifiedEmail = true
+	s := newTestServer(t, cfg)
+
+	rec := s.request(http.MethodPost, "/api/v1/register", `{"name":"Melisa","email":"melisa@example.com","password":"abc12345"}`, "")
+	expectStatus(t, rec, http.StatusOK)
+	var body struct {
+		User              model.UserResponse `json:"user"`
+		VerificationToken string             `json:"verification_token"`
+	}
+	decode(t, rec, &body)
+	if stored, _ := s.users.GetByID(context.Background(), body.User.ID); stored.Verified {
+		t.Error("new user is already verified")
+	}
+
+	loginBody := `{"email":"melisa@example.com","password":"abc12345"}`
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/login", loginBody, ""), http.StatusForbidden)
+
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/verify?token="+body.VerificationToken, "", ""), http.StatusOK)
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/login", loginBody, ""), http.StatusOK)
+}
+
+func TestVerifyRejectsBadTokens(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	user, err := s.users.GetByEmail(context.Background(), "melisa@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := s.generatePurposeToken(user, purposeVerify, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		token string
+		code  string
+	}{
+		{"expired", expired, "token_expired"},
+		{"access token", s.tokenFor(t, user), "invalid_token"},
+		{"garbage", "not-a-token", "invalid_token"},
+		{"missing", "", "invalid_token"},
+	}
+	for _, tt := range tests {
+		rec := s.request(http.MethodGet, "/api/v1/verify?token="+tt.token, "", "")
+		expectStatus(t, rec, http.StatusBadRequest)
+		var body APIError
+		decode(t, rec, &body)
+		if body.Code != tt.code {
+			t.Errorf("%s: code = %q, want %q", tt.name, body.Code, tt.code)
+		}
+	}
+
+	if user, _ := s.users.GetByID(context.Background(), user.ID); user.Verified {
+		t.Error("a rejected token verified the user")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+	change := func(current, next string) *httptest.ResponseRecorder {
+		return s.request(http.MethodPost, "/api/v1/password/change", `{"current_password":"`+current+`","new_password":"`+next+`"}`, token)
+	}
+
+	tests := []struct {
+		name          string
+		current, next string
+		status        int
+	}{
+		{"wrong current password", "wrong1234", "new12345", http.StatusUnauthorized},
+		{"weak new password", "abc12345", "short", http.StatusUnprocessableEntity},
+		{"unchanged password", "abc12345", "abc12345", http.StatusUnprocessableEntity},
+		{"success", "abc12345", "new12345", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectStatus(t, change(tt.current, tt.next), tt.status)
+		})
+	}
+
+	s.login(t, "melisa@example.com", "new12345")
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/login", `{"email":"melisa@example.com","password":"abc12345"}`, ""), http.StatusUnauthorized)
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/password/change", `{"current_password":"new12345","new_password":"abc12345"}`, ""), http.StatusUnauthorized)
+}
+
+func TestChangePasswordEndsSessions(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	stolen := s.loginPair(t, "melisa@example.com", "abc12345")
+	current := s.loginPair(t, "melisa@example.com", "abc12345")
+
+	rec := s.request(http.MethodPost, "/api/v1/password/change", `{"current_password":"abc12345","new_password":"new12345"}`, current.Token)
+	expectStatus(t, rec, http.StatusOK)
+	expectStatus(t, s.refresh(stolen.RefreshToken), http.StatusUnauthorized)
+	expectStatus(t, s.refresh(current.RefreshToken), http.StatusUnauthorized)
+	expectStatus(t, s.refresh(s.loginPair(t, "melisa@example.com", "new12345").RefreshToken), http.StatusOK)
+}
+
+func TestRegisterTrimsInput(t *testing.T) {
+	s := newTestServer(t, testConfig())
+
+	user := s.register(t, `  Melisa \t`, "  Melisa@Example.COM ", "abc12345")
+	if user.Name != "Melisa" || user.Email != "melisa@example.com" {
+		t.Errorf("registered name %q email %q, want them trimmed", user.Name, user.Email)
+	}
+	if _, err := s.users.GetByEmail(context.Background(), "melisa@example.com"); err != nil {
+		t.Errorf("user isn't found by the clean email: %v", err)
+	}
+	s.login(t, " melisa@example.com ", "abc12345")
+
+	// Padding doesn't make a second account
+	rec := s.request(http.MethodPost, "/api/v1/register", `{"name":"Melisa","email":"melisa@example.com  ","password":"abc12345"}`, "")
+	expectStatus(t, rec, http.StatusConflict)
+}
+
+func TestPasswordsAreNotTrimmed(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	s.register(t, "Melisa", "melisa@example.com", " abc12345 ")
+
+	s.login(t, "melisa@example.com", " abc12345 ")
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/login", `{"email":"melisa@example.com","password":"abc12345"}`, ""), http.StatusUnauthorized)
+}
+
+func TestLoginUpgradesHashCost(t *testing.T) {
+	cfg := testConfig()
+	cfg.BcryptCost = bcrypt.MinCost + 1
+	s := newTestServer(t, cfg)
+	ctx := context.Background()
+
+	// A hash made before BCRYPT_COST was raised
+	oldHash, err := bcrypt.GenerateFromPassword([]byte("abc12345"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.users.Create(ctx, model.User{Name: "Melisa", Email: "melisa@example.com", PasswordHash: string(oldHash)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.login(t, "melisa@example.com", "abc12345")
+	upgraded, err := s.users.GetByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, _ := bcrypt.Cost([]byte(upgraded.PasswordHash)); cost != cfg.BcryptCost {
+		t.Errorf("cost after login = %d, want %d", cost, cfg.BcryptCost)
+	}
+	s.login(t, "melisa@example.com", "abc12345")
+
+	// A failed login leaves the hash alone
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/login", `{"email":"melisa@example.com","password":"wrong1234"}`, ""), http.StatusUnauthorized)
+	if after, _ := s.users.GetByID(ctx, user.ID); after.PasswordHash != upgraded.PasswordHash {
+		t.Error("a failed login changed the hash")
+	}
+}
+
+func TestRegisterFieldLengths(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	register := func(name, email string) *httptest.ResponseRecorder {
+		return s.request(http.MethodPost, "/api/v1/register", `{"name":"`+name+`","email":"`+email+`","password":"abc12345"}`, "")
+	}
+
+	rec := register(strings.Repeat("a", 300), "melisa@example.com")
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	var body APIError
+	decode(t, rec, &body)
+	if body.Fields["name"] != "at most 100 characters" {
+		t.Errorf("name error = %q", body.Fields["name"])
+	}
+
+	longEmail := strings.Repeat("a", 64) + "@" + strings.Repeat("b", 190) + ".com"
+	rec = register("Melisa", longEmail)
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	decode(t, rec, &body)
+	if body.Fields["email"] != "at most 254 characters" {
+		t.Errorf("email error = %q", body.Fields["email"])
+	}
+
+	expectStatus(t, register(strings.Repeat("a", 50), "melisa@example.com"), http.StatusOK)
+}
+
+func TestValidateRegistration(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	validate := func(body string) *httptest.ResponseRecorder {
+		return s.request(http.MethodPost, "/api/v1/register/validate", body, "")
+	}
+
+	rec := validate(`{"name":"Ada","email":"ada@example.com","password":"abc12345"}`)
+	expectStatus(t, rec, http.StatusOK)
+	var body map[string]bool
+	decode(t, rec, &body)
+	if !body["valid"] {
+		t.Errorf("body = %s, want valid", rec.Body)
+	}
+	if _, err := s.users.GetByEmail(context.Background(), "ada@example.com"); err == nil {
+		t.Error("the dry run created the user")
+	}
+
+	// A taken email is reported without creating anything
+	rec = validate(`{"name":"Melisa","email":"MELISA@example.com","password":"abc12345"}`)
+	expectStatus(t, rec, http.StatusConflict)
+	if users, _ := s.users.List(context.Background()); len(users) != 1 {
+		t.Errorf("got %d users after the dry runs, want 1", len(users))
+	}
+
+	// Failures look the same as from /register
+	invalid := `{"name":"","email":"ada@example.com","password":"short"}`
+	dryRun, real := validate(invalid), s.request(http.MethodPost, "/api/v1/register", invalid, "")
+	expectStatus(t, dryRun, http.StatusUnprocessableEntity)
+	if dryRun.Body.String() != real.Body.String() {
+		t.Errorf("dry run %s, register %s", dryRun.Body, real.Body)
+	}
+}
+
+func TestAllowedEmailDomains(t *testing.T) {
+	cfg := testConfig()
+	cfg.AllowedEmailDomains = []string{"example.com"}
+	s := newTestServer(t, cfg)
+
+	// Matched after normalization, so case doesn't matter
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	s.register(t, "Ada", "  Ada@EXAMPLE.com ", "abc12345")
+
+	rec := s.request(http.MethodPost, "/api/v1/register", `{"name":"Eve","email":"eve@elsewhere.org","password":"abc12345"}`, "")
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	var body APIError
+	decode(t, rec, &body)
+	if body.Fields["email"] != "domain elsewhere.org is not allowed" {
+		t.Errorf("email error = %q", body.Fields["email"])
+	}
+	if _, err := s.users.GetByEmail(context.Background(), "eve@elsewhere.org"); err == nil {
+		t.Error("a user with a disallowed domain was created")
+	}
+
+	// Without the setting any domain may register
+	open := newTestServer(t, testConfig())
+	open.register(t, "Eve", "eve@elsewhere.org", "abc12345")
+}
+
+func TestLastLoginOnMe(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	me := func(token string) MeResponse {
+		t.Helper()
+		rec := s.request(http.MethodGet, "/api/v1/me", "", token)
+		expectStatus(t, rec, http.StatusOK)
+		var body MeResponse
+		decode(t, rec, &body)
+		return body
+	}
+
+	// A token that didn't come from /login shows no login yet
+	stored, err := s.users.GetByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := me(s.tokenFor(t, stored)); got.LastLoginAt != nil {
+		t.Errorf("last_login_at = %v before any login", got.LastLoginAt)
+	}
+
+	before := time.Now()
+	pair := s.loginPair(t, "melisa@example.com", "abc12345")
+	got := me(pair.Token)
+	if got.LastLoginAt == nil || got.LastLoginAt.Before(before.Truncate(time.Second)) {
+		t.Fatalf("last_login_at = %v, want at or after %v", got.LastLoginAt, before)
+	}
+	if got.ID != user.ID || got.Email != "melisa@example.com" {
+		t.Errorf("me = %+v", got)
+	}
+
+	// Refreshing isn't a password login and leaves it alone
+	time.Sleep(10 * time.Millisecond)
+	rec := s.refresh(pair.RefreshToken)
+	expectStatus(t, rec, http.StatusOK)
+	var refreshed tokenPair
+	decode(t, rec, &refreshed)
+	if after := me(refreshed.Token); after.LastLoginAt == nil || !after.LastLoginAt.Equal(*got.LastLoginAt) {
+		t.Errorf("last_login_at = %v after a refresh, want %v", after.LastLoginAt, got.LastLoginAt)
+	}
+}
+
+// A memory store whose first GetByEmail waits for release, signalling entered when it starts
+type gatedStore struct {
+	*store.MemoryUserStore
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+	lookups atomic.Int32
+}
+
+func (s *gatedStore) GetByEmail(ctx context.Context, email string) (model.User, error) {
+	s.lookups.Add(1)
+	s.once.Do(func() {
+		close(s.entered)
+		<-s.release
+	})
+	return s.MemoryUserStore.GetByEmail(ctx, email)
+}
+
+// Log in concurrently while the first attempt is held in the store, once the
+// others have had time to queue up behind it, release it and return the statuses
+func concurrentLogins(t *testing.T, s *testServer, gs *gatedStore, n int, body string) []int {
+	t.Helper()
+	statuses := make([]int, n)
+	var wg sync.WaitGroup
+	login := func(i int) {
+		defer wg.Done()
+		statuses[i] = s.request(http.MethodPost, "/api/v1/login", body, "").Code
+	}
+
+	wg.Add(n)
+	go login(0)
+	<-gs.entered
+	for i := 1; i < n; i++ {
+		go login(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(gs.release)
+	wg.Wait()
+	return statuses
+}
+
+func newGatedTestServer(t *testing.T) (*testServer, *gatedStore) {
+	t.Helper()
+	mem := store.NewMemoryUserStore()
+	gs := &gatedStore{MemoryUserStore: mem, entered: make(chan struct{}), release: make(chan struct{})}
+	return newTestServerWithStore(t, testConfig(), gs, mem), gs
+}
+
+func TestConcurrentLoginsShareOneCheck(t *testing.T) {
+	s, gs := newGatedTestServer(t)
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	spans := recordSpans(t)
+
+	const n = 20
+	for i, status := range concurrentLogins(t, s, gs, n, `{"email":"melisa@example.com","password":"abc12345"}`) {
+		if status != http.StatusOK {
+			t.Errorf("login %d: status %d, want 200", i, status)
+		}
+	}
+	if got := len(spansNamed(spans, "bcrypt.Compare")); got != 1 {
+		t.Errorf("ran %d bcrypt comparisons for %d concurrent logins, want 1", got, n)
+	}
+	if got := gs.lookups.Load(); got != 1 {
+		t.Errorf("looked the user up %d times, want 1", got)
+	}
+
+	// Later attempts check again rather than reusing the result
+	s.login(t, "melisa@example.com", "abc12345")
+	if got := len(spansNamed(spans, "bcrypt.Compare")); got != 2 {
+		t.Errorf("ran %d bcrypt comparisons after one more login, want 2", got)
+	}
+}
+
+func TestConcurrentFailedLoginsAreNotCached(t *testing.T) {
+	s, gs := newGatedTestServer(t)
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+
+	// Each rejected attempt still counts towards the lockout
+	for i, status := range concurrentLogins(t, s, gs, 3, `{"email":"melisa@example.com","password":"wrong-password"}`) {
+		if status != http.StatusUnauthorized {
+			t.Errorf("login %d: status %d, want 401", i, status)
+		}
+	}
+	if s.lockout.Locked("melisa@example.com") {
+		t.Fatal("3 failures locked the account, the threshold is 5")
+	}
+
+	// A wrong password shared in flight doesn't stick to the right one
+	s.login(t, "melisa@example.com", "abc12345")
+}
+
+// A memory store whose GetByEmail stalls for a while unless its context ends first
+type stallingStore struct {
+	*store.MemoryUserStore
+	stall time.Duration
+}
+
+func (s *stallingStore) GetByEmail(ctx context.Context, email string) (model.User, error) {
+	select {
+	case <-time.After(s.stall):
+	case <-ctx.Done():
+		return model.User{}, ctx.Err()
+	}
+	return s.MemoryUserStore.GetByEmail(ctx, email)
+}
+
+func TestLoginTimesOutOnSlowStore(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequestTimeout = 100 * time.Millisecond
+	mem := store.NewMemoryUserStore()
+	s := newTestServerWithStore(t, cfg, &stallingStore{MemoryUserStore: mem, stall: 3 * time.Second}, mem)
+	if _, err := s.createUser(context.Background(), model.User{Name: "Melisa", Email: "melisa@example.com", Password: "abc12345"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Attempts sharing the stalled check each give up at their deadline
+	start := time.Now()
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, cfg.LockoutThreshold+1)
+	for i := range recs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recs[i] = s.request(http.MethodPost, "/api/v1/login", `{"email":"melisa@example.com","password":"abc12345"}`, "")
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("logins took %v, want about the 100ms timeout", elapsed)
+	}
+	for _, rec := range recs {
+		expectStatus(t, rec, http.StatusServiceUnavailable)
+	}
+
+	// A store that doesn't answer isn't held against the account
+	if s.lockout.Locked("melisa@example.com") {
+		t.Error("timed out logins locked the account")
+	}
+}
+
+func BenchmarkLogin(b *testing.B) {
+	// Every login comes from the same address, keep the rate limit out of the way
+	cfg := testConfig()
+	cfg.RateLimitPerMinute = 1 << 30
+	cfg.RateLimitBurst = 1 << 30
+	s := newTestServer(b, cfg)
+	s.register(b, "Melisa", "melisa@example.com", "abc12345")
+	spans := recordSpans(b)
+	body := `{"email":"melisa@example.com","password":"abc12345"}`
+
+	// Many more logins in flight than CPUs, as from a misbehaving client
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if rec := s.request(http.MethodPost, "/api/v1/login", body, ""); rec.Code != http.StatusOK {
+				b.Errorf("login: status %d", rec.Code)
+			}
+		}
+	})
+	b.ReportMetric(float64(len(spansNamed(spans, "bcrypt.Compare")))/float64(b.N), "bcrypt/op")
+}
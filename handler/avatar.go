@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Largest accepted profile image
+const maxAvatarSize = 2 << 20
+
+// Room left for the multipart boundaries and headers around the image
+const multipartOverhead = 64 << 10
+
+// Accepted image types, detected from the content, and the extension they are stored with
+var avatarTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+}
+
+// Store a PNG or JPEG of at most 2MB, sent as the "avatar" field of a
+// multipart/form-data body, as the user's profile image
+func (h *Handler) UploadAvatar(c echo.Context) error {
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if mediaType != echo.MIMEMultipartForm {
+		return respondError(c, http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type must be multipart/form-data")
+	}
+
+	user, err := h.store.GetByID(c.Request().Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+	case err != nil:
+		return respondStoreError(c, err, "Could not load user")
+	}
+
+	// Stop reading well before a huge body is buffered
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxAvatarSize+multipartOverhead)
+
+	fh, err := c.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return respondError(c, http.StatusRequestEntityTooLarge, "avatar_too_large", "avatar must be at most 2MB")
+		}
+		return respondFieldError(c, "avatar", "required")
+	}
+	if fh.Size > maxAvatarSize {
+		return respondError(c, http.StatusRequestEntityTooLarge, "avatar_too_large", "avatar must be at most 2MB")
+	}
+
+	file, err := fh.Open()
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "internal_error", "Could not read avatar")
+	}
+	defer file.Close()
+
+	// Trust the bytes, not the type the client claims
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return respondError(c, http.StatusInternalServerError, "internal_error", "Could not read avatar")
+	}
+	ext, ok := avatarTypes[http.DetectContentType(data)]
+	if !ok {
+		return respondError(c, http.StatusUnsupportedMediaType, "unsupported_avatar_type", "avatar must be a PNG or JPEG image")
+	}
+
+	path, err := h.saveAvatar(user.ID, ext, data)
+	if err != nil {
+		requestLog(c).Error("could not save avatar", "user_id", user.ID, "error", err)
+		return respondError(c, http.StatusInternalServerError, "internal_error", "Could not save avatar")
+	}
+
+	if err := h.store.SetAvatar(c.Request().Context(), user.ID, path); err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+		}
+		return respondStoreError(c, err, "Could not save avatar")
+	}
+
+	h.auditRequest(c, store.AuditUserAvatar, user.ID)
+
+	// Replacing a PNG with a JPEG leaves the old file behind
+	if user.AvatarPath != "" && user.AvatarPath != path {
+		os.Remove(user.AvatarPath)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Serve a user's profile image
+func (h *Handler) GetAvatar(c echo.Context) error {
+	user, err := h.store.GetByID(c.Request().Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+	case err != nil:
+		return respondStoreError(c, err, "Could not load user")
+	}
+	if user.AvatarPath == "" {
+		return respondError(c, http.StatusNotFound, "avatar_not_found", "user has no avatar")
+	}
+
+	return c.File(user.AvatarPath)
+}
+
+// Write the image to AVATAR_DIR as <user id><ext>, through a temporary file
+// so a request reading the old image never sees a half-written one
+func (h *Handler) saveAvatar(userID, ext string, data []byte) (string, error) {
+	if err := os.MkdirAll(h.cfg.AvatarDir, 0o755); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(h.cfg.AvatarDir, userID+"-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(h.cfg.AvatarDir, userID+ext)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
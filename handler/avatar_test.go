@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Upload data as the "avatar" field of a multipart form
+func (s *testServer) uploadAvatar(t *testing.T, id, token string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("avatar", "avatar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/"+id+"/avatar", &body)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	return s.serve(req)
+}
+
+// A 1x1 image in the given format
+func testImage(t *testing.T, format string) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 1, 1))
+	var buf bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadAvatar(t *testing.T) {
+	cfg := testConfig()
+	cfg.AvatarDir = t.TempDir()
+	s := newTestServer(t, cfg)
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+
+	pngData := testImage(t, "png")
+	expectStatus(t, s.uploadAvatar(t, user.ID, token, pngData), http.StatusNoContent)
+
+	rec := s.request(http.MethodGet, "/api/v1/users/"+user.ID+"/avatar", "", token)
+	expectStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get(echo.HeaderContentType); got != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", got)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), pngData) {
+		t.Error("served avatar differs from the upload")
+	}
+
+	// A JPEG replaces the PNG, leaving only the new file
+	expectStatus(t, s.uploadAvatar(t, user.ID, token, testImage(t, "jpeg")), http.StatusNoContent)
+	rec = s.request(http.MethodGet, "/api/v1/users/"+user.ID+"/avatar", "", token)
+	expectStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get(echo.HeaderContentType); got != "image/jpeg" {
+		t.Errorf("Content-Type = %q, want image/jpeg", got)
+	}
+	files, _ := os.ReadDir(cfg.AvatarDir)
+	if len(files) != 1 || files[0].Name() != user.ID+".jpg" {
+		t.Errorf("avatar dir holds %v, want only %s.jpg", files, user.ID)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.AvatarDir, user.ID+".png")); !os.IsNotExist(err) {
+		t.Errorf("old PNG is still there: %v", err)
+	}
+}
+
+func TestUploadAvatarRejectsBadFiles(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+
+	// The type is detected from the bytes, whatever the client claims
+	rec := s.uploadAvatar(t, user.ID, token, []byte("just some text, not an image"))
+	expectStatus(t, rec, http.StatusUnsupportedMediaType)
+	var body APIError
+	decode(t, rec, &body)
+	if body.Code != "unsupported_avatar_type" {
+		t.Errorf("code = %q, want unsupported_avatar_type", body.Code)
+	}
+
+	oversized := append(testImage(t, "png"), make([]byte, maxAvatarSize)...)
+	expectStatus(t, s.uploadAvatar(t, user.ID, token, oversized), http.StatusRequestEntityTooLarge)
+
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/users/"+user.ID+"/avatar", `{"avatar":"x"}`, token), http.StatusUnsupportedMediaType)
+
+	// Nothing was stored along the way
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/"+user.ID+"/avatar", "", token), http.StatusNotFound)
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Returned by Bind when a JSON body has a key the target struct doesn't define
+type UnknownFieldError struct {
+	Field string
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("unknown field %q", e.Field)
+}
+
+// Returned by Bind when a POST, PUT or PATCH request has no body, or only whitespace
+var errEmptyBody = errors.New("request body required")
+
+// Binder binds requests like echo's default binder, except that JSON bodies with
+// unknown keys are rejected with an *UnknownFieldError and write requests without
+// a body with errEmptyBody. Then it tidies string fields
+// so every write endpoint sees the same clean values:
+//   - leading and trailing whitespace is trimmed, except on fields tagged `trim:"-"`
+//     such as passwords, where spaces are significant
+//   - fields validated as `email` are also lowercased
+type Binder struct {
+	echo.DefaultBinder
+}
+
+func (b *Binder) Bind(i interface{}, c echo.Context) error {
+	if err := b.BindPathParams(c, i); err != nil {
+		return err
+	}
+
+	req := c.Request()
+	switch req.Method {
+	case http.MethodGet, http.MethodDelete, http.MethodHead:
+		if err := b.BindQueryParams(c, i); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch {
+	case req.ContentLength == 0 && hasBody(req.Method):
+		err = errEmptyBody
+	case req.ContentLength != 0 && isJSONRequest(c):
+		err = decodeStrictJSON(c, i)
+	default:
+		err = b.BindBody(c, i)
+	}
+	if err != nil {
+		return err
+	}
+
+	normalizeValue(reflect.ValueOf(i))
+	return nil
+}
+
+// Whether requests with the method carry their input in the body
+func hasBody(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch
+}
+
+// Whether the request body is declared as JSON, with or without a charset
+func isJSONRequest(c echo.Context) bool {
+	base, _, _ := strings.Cut(c.Request().Header.Get(echo.HeaderContentType), ";")
+	return strings.TrimSpace(base) == echo.MIMEApplicationJSON
+}
+
+// Decode a JSON body, failing on keys the target doesn't have
+func decodeStrictJSON(c echo.Context, i interface{}) error {
+	dec := json.NewDecoder(c.Request().Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(i)
+	if err == nil {
+		// The decoder stops at the end of the value, even when the read that
+		// brought it in also ran past BODY_LIMIT. Reading on surfaces that 413.
+		var he *echo.HTTPError
+		if _, err := io.Copy(io.Discard, c.Request().Body); errors.As(err, &he) {
+			return err
+		}
+		return nil
+	}
+
+	// A chunked body, which has no Content-Length, may still turn out empty
+	if errors.Is(err, io.EOF) {
+		return errEmptyBody
+	}
+
+	// Body limit errors are already HTTP errors
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return err
+	}
+
+	// encoding/json reports unknown keys as `json: unknown field "name"`
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		if field, uerr := strconv.Unquote(name); uerr == nil {
+			return &UnknownFieldError{Field: field}
+		}
+	}
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error()).SetInternal(err)
+}
+
+// Walk pointers, slices and structs, normalizing each settable string field
+func normalizeValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			normalizeValue(v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			normalizeValue(v.Index(i))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() || field.Tag.Get("trim") == "-" {
+				continue
+			}
+
+			fv := v.Field(i)
+			if fv.Kind() == reflect.Pointer && !fv.IsNil() && fv.Elem().Kind() == reflect.String {
+				fv = fv.Elem()
+			}
+			if fv.Kind() != reflect.String {
+				normalizeValue(fv)
+				continue
+			}
+
+			s := strings.TrimSpace(fv.String())
+			if isEmailField(field) {
+				s = store.NormalizeEmail(s)
+			}
+			fv.SetString(s)
+		}
+	}
+}
+
+// Whether the field's validate tag includes the email rule
+func isEmailField(field reflect.StructField) bool {
+	for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+		if rule == "email" {
+			return true
+		}
+	}
+	return false
+}
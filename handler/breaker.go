@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// StoreBreaker pings the store on an interval and opens once enough pings in a
+// row have failed. While it is open, every API request gets 503 straight away
+// instead of waiting on a dead database, and only the periodic ping touches
+// the store. The first ping that succeeds closes it again.
+type StoreBreaker struct {
+	store     store.UserStore
+	interval  time.Duration
+	threshold int
+
+	mu       sync.Mutex
+	failures int // consecutive failed pings
+	open     bool
+}
+
+// Create a breaker pinging userStore every interval, opening after threshold failures
+func NewStoreBreaker(userStore store.UserStore, interval time.Duration, threshold int) *StoreBreaker {
+	return &StoreBreaker{store: userStore, interval: interval, threshold: threshold}
+}
+
+// Whether the store is considered down
+func (b *StoreBreaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+// Ping the store right away and then every interval until ctx is done
+func (b *StoreBreaker) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		b.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Ping the store once, giving up before the next ping is due
+func (b *StoreBreaker) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, b.interval)
+	defer cancel()
+	b.record(b.store.Ping(ctx))
+}
+
+// Count a ping's outcome, opening or closing the breaker when it changes state
+func (b *StoreBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		if b.open {
+			slog.Info("store is reachable again, accepting requests")
+		}
+		b.failures = 0
+		b.open = false
+		return
+	}
+
+	b.failures++
+	if !b.open && b.failures >= b.threshold {
+		b.open = true
+		slog.Error("store is unreachable, refusing requests", "failures", b.failures, "error", err)
+	}
+}
+
+// Refuse API requests with 503 while the breaker is open. The probes, /metrics,
+// /version and the docs don't need the store and keep answering, as do CORS
+// preflights.
+func (b *StoreBreaker) Middleware() echo.MiddlewareFunc {
+	retryAfter := strconv.Itoa(max(int(b.interval.Seconds()), 1))
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Method == http.MethodOptions || !strings.HasPrefix(c.Path(), "/api/") {
+				return next(c)
+			}
+			if !b.Open() {
+				return next(c)
+			}
+
+			c.Response().Header().Set("Retry-After", retryAfter)
+			return respondError(c, http.StatusServiceUnavailable, "store_unavailable", "the database is unavailable, try again later")
+		}
+	}
+}
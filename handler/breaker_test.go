@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Serve the API from a failing store watched by a breaker, whose pings the test runs itself
+func newBreakerTestServer(t *testing.T) (*testServer, *failingStore) {
+	t.Helper()
+	discardDefaultLog(t)
+	cfg := testConfig()
+	cfg.StoreHealthInterval = time.Hour
+	cfg.MetricsEnabled = true
+	mem := store.NewMemoryUserStore()
+	fs := &failingStore{MemoryUserStore: mem}
+	return newTestServerWithStore(t, cfg, fs, mem), fs
+}
+
+// The store_breaker_open gauge as /metrics reports it
+func (s *testServer) breakerGauge(t *testing.T) string {
+	t.Helper()
+	for _, line := range strings.Split(s.request(http.MethodGet, "/metrics", "", "").Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, "store_breaker_open "); ok {
+			return value
+		}
+	}
+	t.Fatal("/metrics has no store_breaker_open")
+	return ""
+}
+
+func TestStoreBreakerOpensAndCloses(t *testing.T) {
+	s, fs := newBreakerTestServer(t)
+	ctx := context.Background()
+	body := `{"name":"Melisa","email":"melisa@example.com","password":"abc12345"}`
+	user := s.register(t, "Ada", "ada@example.com", "abc12345")
+	token := s.login(t, "ada@example.com", "abc12345")
+
+	// Failures below the threshold leave it closed
+	fs.down = true
+	for i := 1; i < testConfig().StoreFailureThreshold; i++ {
+		s.breaker.check(ctx)
+	}
+	if s.breaker.Open() || s.breakerGauge(t) != "0" {
+		t.Fatal("breaker opened before the threshold")
+	}
+
+	s.breaker.check(ctx)
+	if !s.breaker.Open() || s.breakerGauge(t) != "1" {
+		t.Fatal("breaker didn't open at the threshold")
+	}
+	rec := s.request(http.MethodPost, "/api/v1/register", body, "")
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("503 has no Retry-After")
+	}
+	var apiErr APIError
+	decode(t, rec, &apiErr)
+	if apiErr.Code != "store_unavailable" {
+		t.Errorf("code = %q, want store_unavailable", apiErr.Code)
+	}
+	expectStatus(t, s.request(http.MethodGet, "/readyz", "", ""), http.StatusServiceUnavailable)
+
+	// Reads are refused too, so they don't wait on the store either
+	rec = s.request(http.MethodGet, "/api/v1/users/"+user.ID, "", token)
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	decode(t, rec, &apiErr)
+	if apiErr.Code != "store_unavailable" {
+		t.Errorf("read code = %q, want store_unavailable", apiErr.Code)
+	}
+
+	// Routes that don't need the store keep answering
+	for _, path := range []string{"/healthz", "/version", "/metrics"} {
+		expectStatus(t, s.request(http.MethodGet, path, "", ""), http.StatusOK)
+	}
+
+	// The readiness probe answers from the breaker even once the store is back
+	fs.down = false
+	expectStatus(t, s.request(http.MethodGet, "/readyz", "", ""), http.StatusServiceUnavailable)
+
+	// One good ping closes it
+	s.breaker.check(ctx)
+	if s.breaker.Open() || s.breakerGauge(t) != "0" {
+		t.Fatal("breaker didn't close after the store recovered")
+	}
+	expectStatus(t, s.request(http.MethodGet, "/readyz", "", ""), http.StatusOK)
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+}
+
+// A memory store whose pings fail while down is set, safe to flip while the breaker runs
+type flakyPingStore struct {
+	*store.MemoryUserStore
+	down atomic.Bool
+}
+
+func (s *flakyPingStore) Ping(ctx context.Context) error {
+	if s.down.Load() {
+		return errStoreDown
+	}
+	return s.MemoryUserStore.Ping(ctx)
+}
+
+func TestStoreBreakerRun(t *testing.T) {
+	discardDefaultLog(t)
+	fs := &flakyPingStore{MemoryUserStore: store.NewMemoryUserStore()}
+	fs.down.Store(true)
+	breaker := NewStoreBreaker(fs, time.Millisecond, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		breaker.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor := func(open bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for breaker.Open() != open {
+			if time.Now().After(deadline) {
+				t.Fatalf("breaker open = %v, want %v", !open, open)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFor(true)
+	fs.down.Store(false)
+	waitFor(false)
+}
@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"net"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Work out the client IP behind c.RealIP(). X-Forwarded-For is only believed
+// from the trusted proxies, so other clients can't pick their own IP to dodge
+// the rate limiter. Without trusted proxies the connection's address is used.
+func ipExtractor(trusted []*net.IPNet) echo.IPExtractor {
+	if len(trusted) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	// Echo trusts loopback, link-local and private addresses unless told otherwise
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, ipNet := range trusted {
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
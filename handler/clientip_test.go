@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestIPExtractor(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		trusted []*net.IPNet
+		remote  string
+		want    string
+	}{
+		{"no trusted proxies", nil, "203.0.113.7:1234", "203.0.113.7"},
+		{"no trusted proxies, private address", nil, "10.1.2.3:1234", "10.1.2.3"},
+		{"from a trusted proxy", []*net.IPNet{proxies}, "10.1.2.3:1234", "198.51.100.9"},
+		{"from an untrusted client", []*net.IPNet{proxies}, "203.0.113.7:1234", "203.0.113.7"},
+		{"private but not listed", []*net.IPNet{proxies}, "192.168.1.5:1234", "192.168.1.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			req.Header.Set(echo.HeaderXForwardedFor, "198.51.100.9")
+			if got := ipExtractor(tt.trusted)(req); got != tt.want {
+				t.Errorf("client IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpoofedForwardedForDoesNotDodgeRateLimit(t *testing.T) {
+	cfg := testConfig()
+	cfg.RateLimitPerMinute = 1
+	cfg.RateLimitBurst = 1
+	s := newTestServer(t, cfg)
+
+	// Every request claims a new address, they still count against the sender
+	login := func(spoofed string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/login", nil)
+		req.RemoteAddr = "203.0.113.7:1234"
+		req.Header.Set(echo.HeaderXForwardedFor, spoofed)
+		return s.serve(req)
+	}
+	if rec := login("198.51.100.1"); rec.Code == http.StatusTooManyRequests {
+		t.Fatal("first request was rate limited")
+	}
+	expectStatus(t, login("198.51.100.2"), http.StatusTooManyRequests)
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Routes that are never turned away, so probes and scrapes still answer under load
+var unlimitedRoutes = map[string]bool{
+	"/healthz": true,
+	"/metrics": true,
+}
+
+// ConcurrencyLimiter caps the number of requests served at the same time.
+// Requests over the cap get 503 straight away instead of waiting for a slot.
+type ConcurrencyLimiter struct {
+	slots chan struct{}
+}
+
+// Create a limiter allowing max requests at once
+func NewConcurrencyLimiter(max int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{slots: make(chan struct{}, max)}
+}
+
+// Number of requests holding a slot
+func (l *ConcurrencyLimiter) InUse() int {
+	return len(l.slots)
+}
+
+// Take a slot for each request and give it back when the handler returns,
+// even if it panics
+func (l *ConcurrencyLimiter) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if unlimitedRoutes[c.Path()] {
+				return next(c)
+			}
+
+			select {
+			case l.slots <- struct{}{}:
+			default:
+				c.Response().Header().Set("Retry-After", "1")
+				return respondError(c, http.StatusServiceUnavailable, "overloaded", "server is busy, try again shortly")
+			}
+			defer func() { <-l.slots }()
+
+			return next(c)
+		}
+	}
+}
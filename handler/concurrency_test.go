@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	const max = 2
+	cfg := testConfig()
+	cfg.MaxConcurrentRequests = max
+	s := newTestServer(t, cfg)
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	s.e.GET("/slow", func(c echo.Context) error {
+		started <- struct{}{}
+		<-release
+		return c.NoContent(http.StatusNoContent)
+	})
+	s.e.GET("/panic", func(c echo.Context) error {
+		panic("boom")
+	})
+	serve := func(path string) *httptest.ResponseRecorder {
+		return s.request(http.MethodGet, path, "", "")
+	}
+
+	// Fill every slot
+	var wg sync.WaitGroup
+	for i := 0; i < max; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rec := serve("/slow"); rec.Code != http.StatusNoContent {
+				t.Errorf("slow request: status %d", rec.Code)
+			}
+		}()
+		<-started
+	}
+
+	rec := serve("/slow")
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("503 has no Retry-After")
+	}
+	expectStatus(t, serve("/healthz"), http.StatusOK)
+
+	close(release)
+	wg.Wait()
+
+	// Panicking handlers give their slots back
+	for i := 0; i < max+1; i++ {
+		expectStatus(t, serve("/panic"), http.StatusInternalServerError)
+	}
+	expectStatus(t, serve("/version"), http.StatusOK)
+}
+
+func TestConcurrencyLimiterInUse(t *testing.T) {
+	limiter := NewConcurrencyLimiter(1)
+	e := echo.New()
+	var inUse int
+	handler := limiter.Middleware()(func(c echo.Context) error {
+		inUse = limiter.InUse()
+		return nil
+	})
+
+	if err := handler(e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())); err != nil {
+		t.Fatal(err)
+	}
+	if inUse != 1 || limiter.InUse() != 0 {
+		t.Errorf("InUse = %d during the request and %d after, want 1 and 0", inUse, limiter.InUse())
+	}
+}
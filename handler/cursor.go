@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// What a cursor token holds, clients only ever see it base64 encoded
+type cursorToken struct {
+	CreatedAt time.Time `json:"c"`
+	ID        string    `json:"i"`
+}
+
+// Encode the position after user as an opaque token
+func encodeCursor(user model.User) string {
+	data, _ := json.Marshal(cursorToken{CreatedAt: user.CreatedAt, ID: user.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Decode a token from encodeCursor, an empty token is the start of the listing
+func decodeCursor(token string) (store.UserCursor, error) {
+	if token == "" {
+		return store.UserCursor{}, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return store.UserCursor{}, errors.New("invalid cursor")
+	}
+	var t cursorToken
+	if err := json.Unmarshal(data, &t); err != nil || t.ID == "" {
+		return store.UserCursor{}, errors.New("invalid cursor")
+	}
+	return store.UserCursor{CreatedAt: t.CreatedAt, ID: t.ID}, nil
+}
+
+// List users oldest first from ?cursor=, which is empty for the first page.
+// next_cursor continues after the last user returned and is empty on the last page.
+func (h *Handler) listUsersByCursor(c echo.Context) error {
+	if c.QueryParam("sort") != "" || c.QueryParam("order") != "" || c.QueryParam("page") != "" {
+		return respondError(c, http.StatusBadRequest, "invalid_cursor", "cursor can't be combined with page, sort or order")
+	}
+
+	after, err := decodeCursor(c.QueryParam("cursor"))
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, "invalid_cursor", err.Error())
+	}
+	_, limit := parsePagination(c)
+
+	// Ask for one more user than fits to know whether there is a next page
+	users, err := h.store.ListAfter(c.Request().Context(), after, includeDeleted(c), limit+1)
+	if err != nil {
+		return respondStoreError(c, err, "Could not list users")
+	}
+	next := ""
+	if len(users) > limit {
+		users = users[:limit]
+		next = encodeCursor(users[len(users)-1])
+	}
+
+	data := make([]model.UserResponse, 0, len(users))
+	for _, user := range users {
+		data = append(data, model.NewUserResponse(user))
+	}
+	return respondJSON(c, http.StatusOK, map[string]interface{}{
+		"data":        data,
+		"limit":       limit,
+		"next_cursor": next,
+	})
+}
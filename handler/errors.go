@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// APIError is the body of every error response, e.g.
+//
+//	{"code": "user_not_found", "error": "user not found"}
+//
+// Validation failures also list the failing fields under "errors".
+type APIError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"error"`
+	Fields  map[string]string `json:"errors,omitempty"`
+}
+
+// Echo context key set by prettyJSON
+const prettyJSONKey = "pretty_json"
+
+// Mark every request so respondJSON indents its response, for PRETTY_JSON
+func prettyJSON() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(prettyJSONKey, true)
+			return next(c)
+		}
+	}
+}
+
+// Write a JSON response, indented when PRETTY_JSON is enabled
+func respondJSON(c echo.Context, status int, v interface{}) error {
+	if pretty, _ := c.Get(prettyJSONKey).(bool); pretty {
+		return c.JSONPretty(status, v, "  ")
+	}
+	return c.JSON(status, v)
+}
+
+// Write an error response in the standard shape
+func respondError(c echo.Context, status int, code, msg string) error {
+	return respondJSON(c, status, APIError{
+		Code:    code,
+		Message: msg,
+	})
+}
+
+// Write a 500 response for a store call that failed, logging why. A call cut
+// short by the REQUEST_TIMEOUT deadline gets the same 503 as requestTimeout gives.
+func respondStoreError(c echo.Context, err error, msg string) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		requestLog(c).Warn("store call timed out", "error", err)
+		return respondError(c, http.StatusServiceUnavailable, "timeout", "request timed out")
+	}
+	requestLog(c).Error("store call failed", "error", err)
+	return respondError(c, http.StatusInternalServerError, "internal_error", msg)
+}
+
+// Write a 400 response for a body that couldn't be bound, saying what is wrong with it:
+// no body at all, a key the endpoint doesn't accept, broken JSON, or a value of the wrong type.
+// Errors Echo raised while the body was read keep their own status, such as the
+// 413 of a chunked body that runs past BODY_LIMIT.
+func respondBindError(c echo.Context, err error) error {
+	var (
+		ufe *UnknownFieldError
+		se  *json.SyntaxError
+		ute *json.UnmarshalTypeError
+		he  *echo.HTTPError
+	)
+	switch {
+	case errors.As(err, &he) && he.Code != http.StatusBadRequest:
+		msg, ok := he.Message.(string)
+		if !ok {
+			msg = http.StatusText(he.Code)
+		}
+		return respondError(c, he.Code, errorCode(he.Code), msg)
+	case errors.Is(err, errEmptyBody):
+		return respondError(c, http.StatusBadRequest, "empty_body", errEmptyBody.Error())
+	case errors.As(err, &ufe):
+		return respondJSON(c, http.StatusBadRequest, APIError{
+			Code:    "unknown_field",
+			Message: ufe.Error(),
+			Fields:  map[string]string{ufe.Field: "unknown"},
+		})
+	case errors.As(err, &se):
+		return respondError(c, http.StatusBadRequest, "malformed_json", fmt.Sprintf("malformed JSON at byte %d", se.Offset))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return respondError(c, http.StatusBadRequest, "malformed_json", "malformed JSON, the body ends early")
+	case errors.As(err, &ute):
+		if ute.Field == "" {
+			return respondError(c, http.StatusBadRequest, "invalid_type", "request body must be "+jsonKind(ute.Type))
+		}
+		return respondJSON(c, http.StatusBadRequest, APIError{
+			Code:    "invalid_type",
+			Message: "invalid type for field " + ute.Field,
+			Fields:  map[string]string{ute.Field: "must be " + jsonKind(ute.Type)},
+		})
+	}
+	return respondError(c, http.StatusBadRequest, "invalid_request", "Invalid request")
+}
+
+// Name a Go type the way JSON clients know it, e.g. "a string" or "an object"
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Pointer:
+		return jsonKind(t.Elem())
+	}
+	return "an object"
+}
+
+// Write a 422 response listing the fields that failed validation
+func (h *Handler) respondValidationError(c echo.Context, err error) error {
+	return respondJSON(c, http.StatusUnprocessableEntity, APIError{
+		Code:    "validation_failed",
+		Message: "Validation failed",
+		Fields:  h.validationErrors(err),
+	})
+}
+
+// Write a 422 response for a single field checked outside the validator
+func respondFieldError(c echo.Context, field, msg string) error {
+	return respondJSON(c, http.StatusUnprocessableEntity, APIError{
+		Code:    "validation_failed",
+		Message: "Validation failed",
+		Fields:  map[string]string{field: msg},
+	})
+}
+
+// Recover from panics in handlers, logging the stack and answering with a JSON 500.
+// The panic message is only logged, never sent to the client.
+func recoverJSON() echo.MiddlewareFunc {
+	return middleware.RecoverWithConfig(middleware.RecoverConfig{
+		DisableStackAll: true, // only the panicking goroutine
+		LogErrorFunc: func(c echo.Context, err error, stack []byte) error {
+			requestLog(c).Error("panic recovered", "error", err, "stack", string(stack))
+			if c.Response().Committed {
+				return nil
+			}
+			return respondError(c, http.StatusInternalServerError, "internal_error", "Internal server error")
+		},
+	})
+}
+
+// Render errors returned by Echo itself (unknown route, body too large, ...)
+// and by handlers in the standard error shape
+func httpErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status := http.StatusInternalServerError
+	msg := "Internal server error"
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		status = he.Code
+		if m, ok := he.Message.(string); ok {
+			msg = m
+		} else {
+			msg = http.StatusText(status)
+		}
+	}
+	if status >= http.StatusInternalServerError {
+		requestLog(c).Error("request failed", "error", err)
+		msg = http.StatusText(status)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = respondError(c, status, errorCode(status), msg)
+	}
+	if err != nil {
+		requestLog(c).Error("could not write error response", "error", err)
+	}
+}
+
+// Derive an error code from a status, e.g. 404 -> "not_found"
+func errorCode(status int) string {
+	if status == http.StatusInternalServerError {
+		return "internal_error"
+	}
+	text := strings.ToLower(http.StatusText(status))
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(text, " ", "_")
+}
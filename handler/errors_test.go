@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+// A register body of about size bytes
+func registerBodyOfSize(size int) string {
+	return `{"name":"` + strings.Repeat("a", size) + `","email":"melisa@example.com","password":"abc12345"}`
+}
+
+// A POST /register request with the body, without a Content-Length when chunked is set
+func registerRequest(body string, chunked bool) *http.Request {
+	var r io.Reader = strings.NewReader(body)
+	if chunked {
+		// httptest only sets ContentLength for the readers it knows
+		r = io.MultiReader(r)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/register", r)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	return req
+}
+
+func TestBodyLimit(t *testing.T) {
+	for _, schema := range []bool{false, true} {
+		cfg := testConfig()
+		cfg.BodyLimit = "1K"
+		cfg.JSONSchemaValidation = schema
+		s := newTestServer(t, cfg)
+
+		for _, chunked := range []bool{false, true} {
+			rec := s.serve(registerRequest(registerBodyOfSize(1024), chunked))
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Errorf("schema %v, chunked %v: status %d, want 413, body %s", schema, chunked, rec.Code, rec.Body)
+				continue
+			}
+			var body APIError
+			decode(t, rec, &body)
+			if body.Code != "request_entity_too_large" {
+				t.Errorf("schema %v, chunked %v: code %q, want request_entity_too_large", schema, chunked, body.Code)
+			}
+		}
+
+		// A body under the limit gets through to validation
+		if rec := s.serve(registerRequest(registerBodyOfSize(50), true)); rec.Code != http.StatusOK {
+			t.Errorf("schema %v: small body status %d, want 200, body %s", schema, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestErrorShape(t *testing.T) {
+	s, fs := newFailingTestServer(t)
+	token := s.adminToken(t)
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	s.e.GET("/fail", func(c echo.Context) error {
+		return errors.New("something broke")
+	})
+
+	tests := []struct {
+		name   string
+		send   func() *httptest.ResponseRecorder
+		status int
+		code   string
+	}{
+		{"bad request", func() *httptest.ResponseRecorder {
+			return s.request(http.MethodPost, "/api/v1/login", `{"email":`, "")
+		}, http.StatusBadRequest, "malformed_json"},
+		{"unknown route", func() *httptest.ResponseRecorder {
+			return s.request(http.MethodGet, "/api/v1/nowhere", "", "")
+		}, http.StatusNotFound, "not_found"},
+		{"store failure", func() *httptest.ResponseRecorder {
+			fs.down = true
+			defer func() { fs.down = false }()
+			return s.request(http.MethodGet, "/api/v1/users/"+user.ID, "", token)
+		}, http.StatusInternalServerError, "internal_error"},
+		{"returned error", func() *httptest.ResponseRecorder {
+			return s.request(http.MethodGet, "/fail", "", "")
+		}, http.StatusInternalServerError, "internal_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := tt.send()
+			expectStatus(t, rec, tt.status)
+			var body map[string]interface{}
+			decode(t, rec, &body)
+			if body["code"] != tt.code || body["error"] == "" || len(body) != 2 {
+				t.Errorf("body = %v, want code %q and an error message only", body, tt.code)
+			}
+			if strings.Contains(rec.Body.String(), "something broke") || strings.Contains(rec.Body.String(), errStoreDown.Error()) {
+				t.Errorf("body leaks the internal error: %s", rec.Body)
+			}
+		})
+	}
+}
+
+func TestPanicAnswersJSON500(t *testing.T) {
+	s, logs := newLoggedTestServer(t)
+	s.e.GET("/panic", func(c echo.Context) error {
+		panic("secret internals")
+	})
+
+	rec := s.request(http.MethodGet, "/panic", "", "")
+	expectStatus(t, rec, http.StatusInternalServerError)
+	if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, echo.MIMEApplicationJSON) {
+		t.Errorf("Content-Type = %q, want JSON", ct)
+	}
+	var body APIError
+	decode(t, rec, &body)
+	if body.Code != "internal_error" {
+		t.Errorf("code = %q, want internal_error", body.Code)
+	}
+	if out := rec.Body.String(); strings.Contains(out, "secret internals") || strings.Contains(out, "goroutine") || strings.Contains(out, ".go:") {
+		t.Errorf("body leaks the panic: %s", out)
+	}
+
+	// The stack only goes to the log
+	lines := logLines(t, logs, "panic recovered")
+	if len(lines) != 1 || !strings.Contains(fmt.Sprint(lines[0]["stack"]), "goroutine") {
+		t.Errorf("panic wasn't logged with its stack: %s", logs)
+	}
+}
+
+func TestRespondJSON(t *testing.T) {
+	for _, pretty := range []bool{false, true} {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		if pretty {
+			c.Set(prettyJSONKey, true)
+		}
+
+		if err := respondJSON(c, http.StatusOK, map[string]string{"status": "ok"}); err != nil {
+			t.Fatal(err)
+		}
+		want := `{"status":"ok"}` + "\n"
+		if pretty {
+			want = "{\n  \"status\": \"ok\"\n}\n"
+		}
+		if rec.Body.String() != want {
+			t.Errorf("pretty %v: body %q, want %q", pretty, rec.Body, want)
+		}
+	}
+}
+
+func TestUnknownFieldsAreRejected(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+
+	tests := []struct {
+		method, path, body, token string
+	}{
+		{http.MethodPost, "/api/v1/register", `{"name":"Ada","emial":"ada@example.com","password":"abc12345"}`, ""},
+		{http.MethodPost, "/api/v1/login", `{"email":"melisa@example.com","password":"abc12345","emial":"x"}`, ""},
+		{http.MethodPut, "/api/v1/users/" + user.ID, `{"name":"Ada","emial":"ada@example.com"}`, token},
+		{http.MethodPatch, "/api/v1/users/" + user.ID, `{"emial":"ada@example.com"}`, token},
+	}
+	for _, tt := range tests {
+		rec := s.request(tt.method, tt.path, tt.body, tt.token)
+		expectStatus(t, rec, http.StatusBadRequest)
+		var body APIError
+		decode(t, rec, &body)
+		if body.Code != "unknown_field" || body.Message != `unknown field "emial"` || body.Fields["emial"] != "unknown" {
+			t.Errorf("%s %s: body %+v", tt.method, tt.path, body)
+		}
+	}
+
+	// The same bodies without the typo go through
+	s.register(t, "Ada", "ada@example.com", "abc12345")
+	expectStatus(t, s.request(http.MethodPatch, "/api/v1/users/"+user.ID, `{"name":"Melisa Acar"}`, token), http.StatusOK)
+}
+
+func TestBindErrors(t *testing.T) {
+	s := newTestServer(t, testConfig())
+
+	tests := []struct {
+		name    string
+		body    string
+		code    string
+		message string
+		field   string
+	}{
+		{"syntax error", `{"name":"Melisa",}`, "malformed_json", "malformed JSON at byte 18", ""},
+		{"truncated", `{"name":"Melisa"`, "malformed_json", "malformed JSON, the body ends early", ""},
+		{"wrong field type", `{"name":42,"email":"melisa@example.com","password":"abc12345"}`, "invalid_type", "invalid type for field name", "name"},
+		{"wrong body type", `["melisa@example.com"]`, "invalid_type", "request body must be an object", ""},
+		{"empty body", "", "empty_body", "request body required", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/register", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := s.serve(req)
+			expectStatus(t, rec, http.StatusBadRequest)
+			var body APIError
+			decode(t, rec, &body)
+			if body.Code != tt.code || body.Message != tt.message {
+				t.Errorf("got %q %q, want %q %q", body.Code, body.Message, tt.code, tt.message)
+			}
+			if tt.field != "" && body.Fields[tt.field] == "" {
+				t.Errorf("no error for field %s: %+v", tt.field, body.Fields)
+			}
+		})
+	}
+}
+
+func TestEmptyBodies(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+
+	expectEmptyBody := func(req *http.Request) {
+		t.Helper()
+		rec := s.serve(req)
+		expectStatus(t, rec, http.StatusBadRequest)
+		var body APIError
+		decode(t, rec, &body)
+		if body.Code != "empty_body" || body.Message != "request body required" {
+			t.Errorf("%s %s: got %q %q", req.Method, req.URL.Path, body.Code, body.Message)
+		}
+	}
+
+	// Every write endpoint binds through the same binder
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPost, "/api/v1/register"},
+		{http.MethodPost, "/api/v1/login"},
+		{http.MethodPost, "/api/v1/token/refresh"},
+		{http.MethodPut, "/api/v1/users/" + user.ID},
+		{http.MethodPatch, "/api/v1/users/" + user.ID},
+		{http.MethodPost, "/api/v1/password/change"},
+	} {
+		req := httptest.NewRequest(route.method, route.path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		expectEmptyBody(req)
+	}
+
+	// Only whitespace, or a chunked body that turns out empty, is no body either
+	expectEmptyBody(registerRequest(" \n\t ", false))
+	expectEmptyBody(registerRequest("", true))
+
+	// A patch of {} is a body, it just changes nothing
+	rec := s.request(http.MethodPatch, "/api/v1/users/"+user.ID, `{}`, token)
+	expectStatus(t, rec, http.StatusOK)
+	var unchanged model.UserResponse
+	decode(t, rec, &unchanged)
+	if unchanged.Name != "Melisa" || !unchanged.UpdatedAt.Equal(user.UpdatedAt) {
+		t.Errorf("empty patch changed the user: %+v, was %+v", unchanged, user)
+	}
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+// Weak ETag of a user's public view. It changes whenever any returned field
+// does, and UpdatedAt is part of that view, so every update gives a new tag.
+func userETag(user model.User) string {
+	body, _ := json.Marshal(model.NewUserResponse(user))
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Whether an If-None-Match or If-Match header lists etag, or is "*".
+// Tags are compared weakly, ignoring the W/ prefix.
+func etagMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
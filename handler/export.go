@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Everything held about the user in GET /users/me/export. The password
+// hash and the avatar's file path are left out, only whether one is set.
+type ExportedUser struct {
+	MeResponse
+	Verified  bool `json:"verified"`
+	HasAvatar bool `json:"has_avatar"`
+}
+
+// Body of GET /users/me/export
+type DataExport struct {
+	ExportedAt time.Time          `json:"exported_at"`
+	User       ExportedUser       `json:"user"`
+	AuditLog   []store.AuditEntry `json:"audit_log"` // entries made by or about the user, oldest first
+}
+
+// Download all data held about the logged-in user, for data subject access requests
+func (h *Handler) ExportMe(c echo.Context) error {
+	ctx := c.Request().Context()
+	user, err := h.store.GetByID(ctx, c.Get(userIDKey).(string))
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+	case err != nil:
+		return respondStoreError(c, err, "Could not load user")
+	}
+
+	entries, err := h.auditLog.ForUser(ctx, user.ID)
+	if err != nil {
+		return respondStoreError(c, err, "Could not export data")
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="user-`+user.ID+`.json"`)
+	return respondJSON(c, http.StatusOK, DataExport{
+		ExportedAt: time.Now().UTC(),
+		User: ExportedUser{
+			MeResponse: MeResponse{
+				UserResponse: model.NewUserResponse(user),
+				LastLoginAt:  user.LastLoginAt,
+			},
+			Verified:  user.Verified,
+			HasAvatar: user.AvatarPath != "",
+		},
+		AuditLog: entries,
+	})
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+func TestExportMe(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	admin := s.adminToken(t)
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	s.register(t, "Ada", "ada@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+	expectStatus(t, s.request(http.MethodPatch, "/api/v1/users/"+user.ID, `{"name":"Melisa Acar"}`, token), http.StatusOK)
+
+	rec := s.request(http.MethodGet, "/api/v1/users/me/export", "", token)
+	expectStatus(t, rec, http.StatusOK)
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="user-`+user.ID+`.json"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+
+	// Nothing that could give the password away
+	stored, err := s.users.GetByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := rec.Body.String()
+	for _, secret := range []string{stored.PasswordHash, "abc12345", "password", "avatar_path"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("export contains %q: %s", secret, body)
+		}
+	}
+
+	var export DataExport
+	decode(t, rec, &export)
+	got := export.User
+	if got.ID != user.ID || got.Name != "Melisa Acar" || got.Email != "melisa@example.com" || got.CreatedAt.IsZero() || got.UpdatedAt.IsZero() {
+		t.Errorf("user = %+v", got)
+	}
+	if got.LastLoginAt == nil || got.Verified || got.HasAvatar || export.ExportedAt.IsZero() {
+		t.Errorf("user = %+v, want a last login, unverified and no avatar", got)
+	}
+
+	// Only the entries concerning this user, oldest first
+	var actions []string
+	for _, entry := range export.AuditLog {
+		if entry.TargetID != user.ID && entry.Actor != user.ID {
+			t.Errorf("entry about someone else: %+v", entry)
+		}
+		actions = append(actions, entry.Action)
+	}
+	if len(actions) != 2 || actions[0] != store.AuditUserCreate || actions[1] != store.AuditUserUpdate {
+		t.Errorf("audit actions = %v, want create then update", actions)
+	}
+
+	// The route is the caller's own data, not /users/:id
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/me/export", "", ""), http.StatusUnauthorized)
+	rec = s.request(http.MethodGet, "/api/v1/users/me/export", "", admin)
+	expectStatus(t, rec, http.StatusOK)
+	decode(t, rec, &export)
+	if export.User.Email != "admin@example.com" {
+		t.Errorf("admin's export is of %s", export.User.Email)
+	}
+}
@@ -0,0 +1,493 @@
+// Package handler serves the HTTP API: the Echo handlers, their middleware
+// and the helpers they share.
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	// https://pkg.go.dev/github.com/go-playground/validator/v10
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	// https://pkg.go.dev/github.com/labstack/echo/v4/middleware
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	// https://pkg.go.dev/go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/crypto/bcrypt"
+	// https://pkg.go.dev/golang.org/x/crypto/bcrypt
+	"golang.org/x/sync/singleflight"
+	// https://pkg.go.dev/golang.org/x/sync/singleflight
+
+	"github.com/melisacar/go-rest-api.git/config"
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Handler serves the API routes from a user store
+type Handler struct {
+	store    store.UserStore
+	auditLog store.AuditLog
+	cfg      config.Config
+
+	// Compared against when a login email is unknown, so the response takes as long
+	dummyHash string
+
+	// Failed login tracking, shared by every /login request
+	lockout *LoginLockout
+
+	// Password checks in flight, shared by identical /login requests
+	logins singleflight.Group
+
+	// Refresh tokens issued at login
+	refreshTokens *RefreshTokens
+
+	// Outstanding password reset tokens, and how they reach the user
+	resetTokens *ResetTokens
+	resetSender ResetSender
+
+	// Responses replayed for retried registrations
+	registerIdempotency *IdempotencyCache
+
+	// Counts served by GET /stats
+	stats statsCache
+
+	// Pages served by GET /users, nil when USERS_CACHE_TTL is 0
+	usersCache *UsersCache
+
+	// Version and commit served by GET /version
+	build BuildInfo
+
+	// Notified of new registrations, nil without WEBHOOK_URL
+	webhook *Webhook
+
+	// Checked against /register bodies, nil unless JSON_SCHEMA_VALIDATION is enabled
+	registerSchema *jsonschema.Schema
+
+	// Refuses writes while on, started from MAINTENANCE_MODE
+	maintenance *Maintenance
+
+	// Refuses writes while the store is down, nil when STORE_HEALTH_INTERVAL is 0
+	breaker *StoreBreaker
+}
+
+// Create a Handler for the store, writes are recorded in auditLog and build is
+// reported by GET /version
+func New(userStore store.UserStore, auditLog store.AuditLog, cfg config.Config, build BuildInfo) *Handler {
+	dummy, _ := bcrypt.GenerateFromPassword([]byte("dummy-password"), cfg.BcryptCost)
+
+	h := &Handler{
+		store:               userStore,
+		auditLog:            auditLog,
+		cfg:                 cfg,
+		dummyHash:           string(dummy),
+		lockout:             NewLoginLockout(cfg.LockoutThreshold, cfg.LockoutCooldown),
+		refreshTokens:       NewRefreshTokens(cfg.RefreshTokenTTL),
+		resetTokens:         NewResetTokens(),
+		registerIdempotency: NewIdempotencyCache(cfg.IdempotencyTTL),
+		build:               build,
+		webhook:             NewWebhook(cfg.WebhookURL, cfg.WebhookSecret),
+		maintenance:         NewMaintenance(cfg.MaintenanceMode, cfg.MaintenanceMessage, cfg.MaintenanceRetryAfter),
+	}
+	if cfg.JSONSchemaValidation {
+		h.registerSchema = mustCompileSchema("register.json")
+	}
+
+	// Watch the store itself, the pings needn't go through the wrappers below
+	if cfg.StoreHealthInterval > 0 {
+		h.breaker = NewStoreBreaker(userStore, cfg.StoreHealthInterval, cfg.StoreFailureThreshold)
+	}
+
+	// Time every store call when tracing
+	if cfg.TracingEnabled() {
+		h.store = tracingStore{UserStore: h.store}
+	}
+
+	// Writes go through the store wrapper so they empty the cache
+	if cfg.UsersCacheTTL > 0 {
+		h.usersCache = NewUsersCache(cfg.UsersCacheTTL)
+		h.store = invalidatingStore{UserStore: h.store, cache: h.usersCache}
+	}
+	return h
+}
+
+// Set up e to render errors as JSON, bind and validate request bodies,
+// and run the middleware every request goes through
+func (h *Handler) Configure(e *echo.Echo, logger *slog.Logger) {
+	cfg := h.cfg
+
+	// Render every error in the standard JSON shape
+	e.HTTPErrorHandler = httpErrorHandler
+
+	// Trim request fields as they are bound, before validation
+	e.Binder = &Binder{}
+
+	// Validator for the `validate` struct tags
+	e.Validator = newValidator(cfg.PasswordMinLength)
+
+	// Client IPs for the rate limiter and the logs
+	e.IPExtractor = ipExtractor(cfg.TrustedProxies)
+
+	// Indent every JSON response when PRETTY_JSON is enabled
+	if cfg.PrettyJSON {
+		e.Use(prettyJSON())
+	}
+
+	// A span per request, named after its route. It comes first so requestID
+	// can tag it with the request id.
+	if cfg.TracingEnabled() {
+		e.Use(otelecho.Middleware(serviceName))
+	}
+
+	// Middleware to tag requests with an id and log them as JSON
+	e.Use(requestID(logger))
+	e.Use(requestLogger(logger, cfg.SlowRequestThreshold))
+
+	// Let browsers on the allowed origins call the API. It runs before any
+	// middleware that can turn a request away, so those errors can be read too.
+	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins:  cfg.AllowedOrigins,
+		AllowMethods:  []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		AllowHeaders:  []string{echo.HeaderAuthorization, echo.HeaderContentType, "If-Match", "If-None-Match"},
+		ExposeHeaders: []string{"ETag", "Link"},
+	}))
+
+	// Prometheus metrics, served at /metrics
+	var metrics *Metrics
+	if cfg.MetricsEnabled {
+		metrics = NewMetrics()
+		e.Use(metrics.Middleware())
+		e.GET("/metrics", metrics.Handler())
+		if h.usersCache != nil {
+			metrics.TrackUsersCache(h.usersCache)
+		}
+		if h.breaker != nil {
+			metrics.TrackStoreBreaker(h.breaker)
+		}
+	}
+
+	// Turn panics into JSON 500 responses
+	e.Use(recoverJSON())
+
+	// Security headers. Strict-Transport-Security is only sent on HTTPS requests,
+	// including ones a proxy forwards with X-Forwarded-Proto: https.
+	e.Use(middleware.SecureWithConfig(middleware.SecureConfig{
+		ContentTypeNosniff:    "nosniff",
+		XFrameOptions:         cfg.FrameOptions,
+		ContentSecurityPolicy: cfg.ContentSecurityPolicy,
+		HSTSMaxAge:            cfg.HSTSMaxAge,
+	}))
+
+	// Turn requests away with 503 once too many are being served
+	if cfg.MaxConcurrentRequests > 0 {
+		limiter := NewConcurrencyLimiter(cfg.MaxConcurrentRequests)
+		e.Use(limiter.Middleware())
+		if metrics != nil {
+			metrics.TrackConcurrency(limiter)
+		}
+	}
+
+	// Keep the API read-only during maintenance, and turn it away while the store is down
+	e.Use(h.maintenance.Middleware())
+	if h.breaker != nil {
+		e.Use(h.breaker.Middleware())
+	}
+
+	// Compress large responses
+	if cfg.GzipLevel > 0 {
+		e.Use(compress(cfg.GzipLevel))
+	}
+
+	// Reject oversized bodies with 413 before they are read
+	e.Use(middleware.BodyLimitWithConfig(middleware.BodyLimitConfig{
+		Limit: cfg.BodyLimit,
+		Skipper: func(c echo.Context) bool {
+			return uploadRoutes[c.Path()]
+		},
+	}))
+
+	// Only accept JSON bodies on write endpoints
+	e.Use(requireJSON())
+
+	// Cancel requests that run past the deadline
+	e.Use(requestTimeout(cfg.RequestTimeout))
+}
+
+// Ping the store until ctx is done, so API requests are refused while it is down.
+// It returns straight away when STORE_HEALTH_INTERVAL is 0.
+func (h *Handler) WatchStore(ctx context.Context) {
+	if h.breaker != nil {
+		h.breaker.Run(ctx)
+	}
+}
+
+// Deliver password reset tokens with send. Without one, reset requests are
+// only logged and no token reaches the user.
+func (h *Handler) SetResetSender(send ResetSender) {
+	h.resetSender = send
+}
+
+// Maintenance mode switch, flipped by main on SIGUSR1
+func (h *Handler) Maintenance() *Maintenance {
+	return h.maintenance
+}
+
+// Wire every route to its handler method. The probes and /version stay at the
+// root, the API itself is served under /api/v1.
+func RegisterRoutes(e *echo.Echo, h *Handler) {
+
+	// Health probes and build info
+	e.GET("/healthz", h.Healthz)
+	e.GET("/readyz", h.Readyz)
+	e.GET("/version", h.Version)
+
+	registerV1(e.Group("/api/v1"), h)
+}
+
+// Routes of the v1 API. A later version can call this on its own group and
+// then register the routes whose handlers it overrides.
+func registerV1(g *echo.Group, h *Handler) {
+
+	// Registration, login and tokens
+	g.POST("/register", h.Register, rateLimit(h.cfg.RateLimitPerMinute, h.cfg.RateLimitBurst), h.registerIdempotency.Middleware(), validateSchema(h.registerSchema))
+	g.POST("/register/validate", h.ValidateRegistration, rateLimit(h.cfg.RateLimitPerMinute, h.cfg.RateLimitBurst), validateSchema(h.registerSchema))
+	g.GET("/verify", h.Verify)
+	g.POST("/login", h.Login, rateLimit(h.cfg.RateLimitPerMinute, h.cfg.RateLimitBurst))
+	g.POST("/token/refresh", h.RefreshToken)
+	g.POST("/logout", h.Logout)
+
+	// Passwords and the current user
+	g.POST("/password/reset-request", h.RequestPasswordReset, rateLimit(h.cfg.RateLimitPerMinute, h.cfg.RateLimitBurst))
+	g.POST("/password/reset", h.ResetPassword)
+	g.POST("/password/change", h.ChangePassword, JWTAuth(h.cfg.JWTSecret))
+	g.GET("/me", h.Me, JWTAuth(h.cfg.JWTSecret))
+	g.GET("/users/me/export", h.ExportMe, JWTAuth(h.cfg.JWTSecret))
+
+	// Only admins may list, delete and restore users, or see the stats and audit log
+	adminOnly := []echo.MiddlewareFunc{JWTAuth(h.cfg.JWTSecret), RequireRole(model.RoleAdmin)}
+	// A user may see and edit their own profile and avatar, admins anyone's
+	selfOrAdmin := []echo.MiddlewareFunc{JWTAuth(h.cfg.JWTSecret), RequireSelfOrRole(model.RoleAdmin)}
+
+	// Users
+	g.GET("/users", h.ListUsers, adminOnly...)
+	g.GET("/users/search", h.SearchUsers, adminOnly...)
+	g.GET("/users.csv", h.ExportUsersCSV, adminOnly...)
+	g.POST("/users/bulk", h.BulkRegister, adminOnly...)
+	g.POST("/users/batch-delete", h.BatchDeleteUsers, adminOnly...)
+	g.GET("/users/:id", h.GetUser, selfOrAdmin...)
+	g.PUT("/users/:id", h.UpdateUser, selfOrAdmin...)
+	g.PATCH("/users/:id", h.PatchUser, selfOrAdmin...)
+	g.GET("/users/:id/avatar", h.GetAvatar, selfOrAdmin...)
+	g.POST("/users/:id/avatar", h.UploadAvatar, selfOrAdmin...)
+	g.DELETE("/users/:id", h.DeleteUser, adminOnly...)
+	g.POST("/users/:id/restore", h.RestoreUser, adminOnly...)
+	g.GET("/stats", h.Stats, adminOnly...)
+	g.GET("/audit", h.ListAudit, adminOnly...)
+}
+
+// Liveness probe, only reports that the process is up
+func (h *Handler) Healthz(c echo.Context) error {
+	return respondJSON(c, http.StatusOK, map[string]string{
+		"status": "ok",
+	})
+}
+
+// Readiness probe, checks the store can be reached. While the breaker is
+// open it answers from the breaker rather than pinging the store again.
+func (h *Handler) Readyz(c echo.Context) error {
+	if h.breaker != nil && h.breaker.Open() {
+		return respondJSON(c, http.StatusServiceUnavailable, map[string]string{
+			"status": "unavailable",
+		})
+	}
+	if err := h.store.Ping(c.Request().Context()); err != nil {
+		return respondJSON(c, http.StatusServiceUnavailable, map[string]string{
+			"status": "unavailable",
+		})
+	}
+	return respondJSON(c, http.StatusOK, map[string]string{
+		"status": "ok",
+	})
+}
+
+// CustomValidator runs the `validate` struct tags through go-playground/validator.
+// It satisfies Echo's Validator interface, so handlers can call c.Validate.
+type CustomValidator struct {
+	validator *validator.Validate
+}
+
+// Validate checks the given struct against its `validate` tags
+func (cv *CustomValidator) Validate(i interface{}) error {
+	return cv.validator.Struct(i)
+}
+
+// Create a validator that reports fields by their JSON names and requires
+// passwords of at least minLength characters
+func newValidator(minLength int) *CustomValidator {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	// Use the json tag as the field name, e.g. "password" instead of "Password"
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	// Use our own email check for the `email` tag
+	v.RegisterValidation("email", func(fl validator.FieldLevel) bool {
+		return model.IsValidEmail(fl.Field().String())
+	})
+
+	// Enforce the password policy with the `password` tag
+	v.RegisterValidation("password", validatePasswordField(minLength))
+
+	return &CustomValidator{validator: v}
+}
+
+// Convert validation errors into a field-by-field map, e.g. {"password": "required"}
+func (h *Handler) validationErrors(err error) map[string]string {
+	fields := map[string]string{}
+
+	var errs validator.ValidationErrors
+	if errors.As(err, &errs) {
+		for _, fe := range errs {
+			fields[fe.Field()] = fe.Tag()
+
+			// Say what the length limit is
+			if fe.Tag() == "max" {
+				fields[fe.Field()] = "at most " + fe.Param() + " characters"
+			}
+
+			// Explain what the password policy is missing
+			if pw, ok := fe.Value().(string); ok && fe.Tag() == "password" {
+				fields[fe.Field()] = passwordErrorMessage(pw, h.cfg.PasswordMinLength)
+			}
+		}
+	}
+
+	return fields
+}
+
+// Pagination defaults for list endpoints
+const (
+	defaultPage  = 1
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+// Read ?page= and ?limit=, falling back to the defaults for missing or invalid values
+func parsePagination(c echo.Context) (page, limit int) {
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		page = defaultPage
+	}
+
+	limit, err = strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultLimit
+	}
+	limit = min(limit, maxLimit)
+
+	return page, limit
+}
+
+// Read ?sort= and ?order=, defaulting to name ascending.
+// Unknown values are an error rather than silently ignored.
+func parseSort(c echo.Context) (store.UserSort, error) {
+	sort := store.DefaultUserSort
+	if field := c.QueryParam("sort"); field != "" {
+		if !store.IsSortField(field) {
+			return store.UserSort{}, fmt.Errorf("sort must be one of %s, %s or %s", store.SortByName, store.SortByEmail, store.SortByCreatedAt)
+		}
+		sort.Field = field
+	}
+
+	switch c.QueryParam("order") {
+	case "", "asc":
+	case "desc":
+		sort.Desc = true
+	default:
+		return store.UserSort{}, errors.New("order must be asc or desc")
+	}
+	return sort, nil
+}
+
+// Hash the password of a validated user, drop the plaintext and store the user
+func (h *Handler) createUser(ctx context.Context, user model.User) (model.User, error) {
+	hash, err := h.hashPassword(ctx, user.Password)
+	if err != nil {
+		return model.User{}, err
+	}
+	user.PasswordHash = hash
+	user.Password = ""
+	user.Email = store.NormalizeEmail(user.Email)
+
+	return h.store.Create(ctx, user)
+}
+
+// Whether users may register with email, always true without ALLOWED_EMAIL_DOMAINS
+func (h *Handler) emailDomainAllowed(email string) bool {
+	if len(h.cfg.AllowedEmailDomains) == 0 {
+		return true
+	}
+	return slices.Contains(h.cfg.AllowedEmailDomains, emailDomain(email))
+}
+
+// Whether user may change their email to email. Keeping the current one is
+// always allowed, even if its domain has since been dropped from the list.
+func (h *Handler) emailChangeAllowed(user model.User, email string) bool {
+	return store.NormalizeEmail(email) == user.Email || h.emailDomainAllowed(email)
+}
+
+// The normalized part of an email after the @
+func emailDomain(email string) string {
+	return email[strings.LastIndex(email, "@")+1:]
+}
+
+// Hash a plaintext password with bcrypt at BCRYPT_COST
+func (h *Handler) hashPassword(ctx context.Context, plain string) (string, error) {
+	_, span := startSpan(ctx, "bcrypt.Hash", attribute.Int("bcrypt.cost", h.cfg.BcryptCost))
+	hash, err := bcrypt.GenerateFromPassword([]byte(plain), h.cfg.BcryptCost)
+	endSpan(span, err)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Whether a bcrypt hash was made at a lower cost than BCRYPT_COST
+func (h *Handler) needsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost < h.cfg.BcryptCost
+}
+
+// Replace a user's hash with one at the current cost, using the plaintext
+// that was just checked at login. Failing only costs the upgrade, not the login.
+func (h *Handler) rehashPassword(c echo.Context, user model.User, plain string) {
+	hash, err := h.hashPassword(c.Request().Context(), plain)
+	if err == nil {
+		err = h.store.UpdatePassword(c.Request().Context(), user.ID, hash)
+	}
+	if err != nil {
+		requestLog(c).Warn("could not upgrade password hash", "user_id", user.ID, "error", err)
+		return
+	}
+	requestLog(c).Info("password hash upgraded", "user_id", user.ID, "cost", h.cfg.BcryptCost)
+}
+
+// Check a plaintext password against a bcrypt hash
+func checkPassword(ctx context.Context, hash, plain string) bool {
+	_, span := startSpan(ctx, "bcrypt.Compare")
+	defer span.End()
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain)) == nil
+}
@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/melisacar/go-rest-api.git/config"
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Settings for handler tests: the defaults of config.Load, but with the
+// cheapest bcrypt cost, no rate limit, metrics, cache or background pings
+func testConfig() config.Config {
+	return config.Config{
+		Port:                  1212,
+		JWTSecret:             "test-secret",
+		BcryptCost:            bcrypt.MinCost,
+		PasswordMinLength:     8,
+		RefreshTokenTTL:       time.Hour,
+		IdempotencyTTL:        time.Hour,
+		StoreFailureThreshold: 3,
+		MaintenanceMessage:    "service is under maintenance, try again later",
+		MaintenanceRetryAfter: time.Minute,
+		AllowedOrigins:        []string{"*"},
+		RateLimitPerMinute:    6000,
+		RateLimitBurst:        1000,
+		LockoutThreshold:      5,
+		LockoutCooldown:       15 * time.Minute,
+		BodyLimit:             "1M",
+		RequestTimeout:        30 * time.Second,
+		ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+		FrameOptions:          "DENY",
+	}
+}
+
+// A Handler on a MemoryUserStore, served by its own Echo instance
+type testServer struct {
+	*Handler
+	e     *echo.Echo
+	users *store.MemoryUserStore
+}
+
+// Serve the API from a fresh memory store, logging nowhere
+func newTestServer(t testing.TB, cfg config.Config) *testServer {
+	t.Helper()
+	users := store.NewMemoryUserStore()
+	return newTestServerWithStore(t, cfg, users, users)
+}
+
+// Serve the API from userStore, mem is the memory store underneath it if any
+func newTestServerWithStore(t testing.TB, cfg config.Config, userStore store.UserStore, mem *store.MemoryUserStore) *testServer {
+	t.Helper()
+	return newTestServerWithLogger(t, cfg, userStore, mem, slog.New(slog.NewJSONHandler(io.Discard, nil)))
+}
+
+// Serve the API from userStore, logging to logger
+func newTestServerWithLogger(t testing.TB, cfg config.Config, userStore store.UserStore, mem *store.MemoryUserStore, logger *slog.Logger) *testServer {
+	t.Helper()
+	if cfg.AvatarDir == "" {
+		cfg.AvatarDir = t.TempDir()
+	}
+	auditLog, err := store.NewFileAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLog.Close() })
+
+	h := New(userStore, auditLog, cfg, BuildInfo{Version: "test", Commit: "none"})
+	e := echo.New()
+	h.Configure(e, logger)
+	RegisterRoutes(e, h)
+	return &testServer{Handler: h, e: e, users: mem}
+}
+
+// Silence the default logger until the test ends, for code that logs through slog directly
+func discardDefaultLog(t testing.TB) {
+	t.Helper()
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+}
+
+// Send a request with an optional JSON body and bearer token
+func (s *testServer) request(method, path, body, token string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	return s.serve(req)
+}
+
+// Send a prepared request
+func (s *testServer) serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// Register a user through the API and return it
+func (s *testServer) register(t testing.TB, name, email, password string) model.UserResponse {
+	t.Helper()
+	rec := s.request(http.MethodPost, "/api/v1/register", `{"name":"`+name+`","email":"`+email+`","password":"`+password+`"}`, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("register %s: status %d, body %s", email, rec.Code, rec.Body)
+	}
+	var body struct {
+		User model.UserResponse `json:"user"`
+	}
+	decode(t, rec, &body)
+	return body.User
+}
+
+// Log in through the API and return the access token
+func (s *testServer) login(t *testing.T, email, password string) string {
+	t.Helper()
+	rec := s.request(http.MethodPost, "/api/v1/login", `{"email":"`+email+`","password":"`+password+`"}`, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login %s: status %d, body %s", email, rec.Code, rec.Body)
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	decode(t, rec, &body)
+	return body.Token
+}
+
+// Create an admin straight in the store and return an access token for them
+func (s *testServer) adminToken(t *testing.T) string {
+	t.Helper()
+	admin, err := s.createUser(context.Background(), model.User{
+		Name:     "admin",
+		Email:    "admin@example.com",
+		Password: "admin1234",
+		Role:     model.RoleAdmin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.tokenFor(t, admin)
+}
+
+// Access token for a user, as login would issue it
+func (s *testServer) tokenFor(t *testing.T, user model.User) string {
+	t.Helper()
+	token, err := s.generateToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// Decode a JSON response body into v
+func decode(t testing.TB, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body, err)
+	}
+}
+
+// Check the status of a response, showing the body when it's wrong
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d, body %s", rec.Code, status, rec.Body)
+	}
+}
+
+func TestHandlersKeepTheirOwnSettings(t *testing.T) {
+	cfgA := testConfig()
+	cfgA.JWTSecret = "secret-a"
+	cfgA.PasswordMinLength = 8
+	a := newTestServer(t, cfgA)
+
+	cfgB := testConfig()
+	cfgB.JWTSecret = "secret-b"
+	cfgB.PasswordMinLength = 12
+	cfgB.PrettyJSON = true
+	b := newTestServer(t, cfgB)
+
+	// Creating b after a mustn't change the secret a signs with
+	a.register(t, "Melisa", "melisa@example.com", "abc12345")
+	token := a.login(t, "melisa@example.com", "abc12345")
+	expectStatus(t, a.request(http.MethodGet, "/api/v1/me", "", token), http.StatusOK)
+	expectStatus(t, b.request(http.MethodGet, "/api/v1/me", "", token), http.StatusUnauthorized)
+
+	// Nor the password policy or formatting a applies
+	rec := b.request(http.MethodPost, "/api/v1/register", `{"name":"Melisa","email":"melisa@example.com","password":"abc12345"}`, "")
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	if !strings.Contains(rec.Body.String(), "at least 12 characters") {
+		t.Errorf("body = %s, want the 12 character minimum", rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), "\n  ") {
+		t.Errorf("b's response isn't indented: %s", rec.Body)
+	}
+	if rec := a.request(http.MethodGet, "/healthz", "", ""); strings.Contains(rec.Body.String(), "\n  ") {
+		t.Errorf("a's response is indented: %s", rec.Body)
+	}
+}
+
+func TestHashPasswordUsesConfiguredCost(t *testing.T) {
+	cfg := testConfig()
+	cfg.BcryptCost = bcrypt.MinCost + 1
+	s := newTestServer(t, cfg)
+
+	hash, err := s.hashPassword(context.Background(), "abc12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, _ := bcrypt.Cost([]byte(hash)); cost != cfg.BcryptCost {
+		t.Errorf("cost = %d, want %d", cost, cfg.BcryptCost)
+	}
+	if cost, _ := bcrypt.Cost([]byte(s.dummyHash)); cost != cfg.BcryptCost {
+		t.Errorf("dummy hash cost = %d, want %d", cost, cfg.BcryptCost)
+	}
+}
+
+func TestRegisterThenGetFromMemoryStore(t *testing.T) {
+	s := newTestServer(t, testConfig())
+
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	stored, err := s.users.GetByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("registered user isn't in the store: %v", err)
+	}
+	if stored.Password != "" || stored.PasswordHash == "" {
+		t.Errorf("stored user should only keep the hash, got password %q hash %q", stored.Password, stored.PasswordHash)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	s, fs := newFailingTestServer(t)
+	fs.down = true
+
+	// Liveness doesn't depend on the store
+	rec := s.request(http.MethodGet, "/healthz", "", "")
+	expectStatus(t, rec, http.StatusOK)
+	var body map[string]string
+	decode(t, rec, &body)
+	if body["status"] != "ok" {
+		t.Errorf("status = %q, want ok", body["status"])
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	s, fs := newFailingTestServer(t)
+
+	rec := s.request(http.MethodGet, "/readyz", "", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	fs.down = true
+	rec = s.request(http.MethodGet, "/readyz", "", "")
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	var body map[string]string
+	decode(t, rec, &body)
+	if body["status"] != "unavailable" {
+		t.Errorf("status = %q, want unavailable", body["status"])
+	}
+}
+
+func TestRoutesAreVersioned(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	body := `{"name":"Melisa","email":"melisa@example.com","password":"abc12345"}`
+
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/register", body, ""), http.StatusOK)
+	expectStatus(t, s.request(http.MethodPost, "/register", body, ""), http.StatusNotFound)
+	expectStatus(t, s.request(http.MethodPost, "/login", `{"email":"melisa@example.com","password":"abc12345"}`, ""), http.StatusNotFound)
+	expectStatus(t, s.request(http.MethodGet, "/healthz", "", ""), http.StatusOK)
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/healthz", "", ""), http.StatusNotFound)
+}
+
+func TestVersion(t *testing.T) {
+	s := newTestServer(t, testConfig())
+
+	rec := s.request(http.MethodGet, "/version", "", "")
+	expectStatus(t, rec, http.StatusOK)
+	var body map[string]string
+	decode(t, rec, &body)
+	if body["version"] != "test" || body["commit"] != "none" || body["go"] != runtime.Version() {
+		t.Errorf("version = %v", body)
+	}
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Header clients set to make a request safe to retry
+const headerIdempotencyKey = "Idempotency-Key"
+
+// Keep at most this many keys, the oldest are evicted first
+const maxIdempotencyKeys = 10000
+
+// Response saved for one idempotency key
+type idempotentResponse struct {
+	bodyHash    [32]byte
+	done        bool // false while the first request is still being handled
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+// IdempotencyCache remembers responses by Idempotency-Key for a TTL, so a
+// retried request gets the original response instead of being handled twice
+type IdempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+	ttl     time.Duration
+}
+
+// Create an empty cache keeping responses for ttl
+func NewIdempotencyCache(ttl time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{
+		entries: map[string]*idempotentResponse{},
+		ttl:     ttl,
+	}
+}
+
+// Replay the saved response when a request repeats an Idempotency-Key.
+// Reusing a key with a different body is rejected with 422, and repeating
+// it while the first request is still running with 409.
+// Requests without the header are handled normally.
+func (ic *IdempotencyCache) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(headerIdempotencyKey)
+			if key == "" {
+				return next(c)
+			}
+
+			// Read the body to fingerprint it, then put it back for the handler
+			body, err := io.ReadAll(c.Request().Body)
+			if err != nil {
+				return err
+			}
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+			bodyHash := sha256.Sum256(body)
+
+			saved, isNew := ic.reserve(key, bodyHash)
+			switch {
+			case saved.bodyHash != bodyHash:
+				return respondError(c, http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key was already used with a different request body")
+			case !isNew && !saved.done:
+				return respondError(c, http.StatusConflict, "request_in_progress", "a request with this Idempotency-Key is still in progress")
+			case !isNew:
+				c.Response().Header().Set(echo.HeaderContentType, saved.contentType)
+				c.Response().WriteHeader(saved.status)
+				_, err := c.Response().Write(saved.body)
+				return err
+			}
+
+			// First request with this key: record what the handler writes
+			rec := &responseRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = rec
+			err = next(c)
+			if err != nil {
+				c.Error(err)
+			}
+
+			// Only keep final answers, a failed request may be retried with the same key
+			status := c.Response().Status
+			if status >= http.StatusInternalServerError {
+				ic.release(key)
+			} else {
+				ic.complete(key, status, c.Response().Header().Get(echo.HeaderContentType), rec.body.Bytes())
+			}
+			return nil
+		}
+	}
+}
+
+// Return the entry for key, creating a pending one if the key is new
+func (ic *IdempotencyCache) reserve(key string, bodyHash [32]byte) (*idempotentResponse, bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := ic.entries[key]; ok && now.Before(entry.expires) {
+		return entry, false
+	}
+
+	ic.evict(now)
+	entry := &idempotentResponse{bodyHash: bodyHash, expires: now.Add(ic.ttl)}
+	ic.entries[key] = entry
+	return entry, true
+}
+
+// Save the response for a pending key
+func (ic *IdempotencyCache) complete(key string, status int, contentType string, body []byte) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	if entry, ok := ic.entries[key]; ok {
+		entry.done = true
+		entry.status = status
+		entry.contentType = contentType
+		entry.body = body
+	}
+}
+
+// Forget a pending key
+func (ic *IdempotencyCache) release(key string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	delete(ic.entries, key)
+}
+
+// Drop expired entries, then the oldest ones while the cache is full. The caller holds the lock.
+func (ic *IdempotencyCache) evict(now time.Time) {
+	for key, entry := range ic.entries {
+		if now.After(entry.expires) {
+			delete(ic.entries, key)
+		}
+	}
+	for len(ic.entries) >= maxIdempotencyKeys {
+		var oldestKey string
+		var oldest time.Time
+		for key, entry := range ic.entries {
+			if oldestKey == "" || entry.expires.Before(oldest) {
+				oldestKey, oldest = key, entry.expires
+			}
+		}
+		delete(ic.entries, oldestKey)
+	}
+}
+
+// Copies everything written to the response into body
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := r.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("response writer does not support hijacking")
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// A POST /register with an Idempotency-Key
+func idempotentRegister(key, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/register", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(headerIdempotencyKey, key)
+	return req
+}
+
+func TestIdempotentRegister(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+	body := `{"name":"Melisa","email":"melisa@example.com","password":"abc12345"}`
+
+	first := s.serve(idempotentRegister("key-1", body))
+	expectStatus(t, first, http.StatusOK)
+	replay := s.serve(idempotentRegister("key-1", body))
+	expectStatus(t, replay, http.StatusOK)
+	if replay.Body.String() != first.Body.String() {
+		t.Errorf("replay = %s, want the original %s", replay.Body, first.Body)
+	}
+	if page := s.listUsers(t, token, ""); page.Total != 2 {
+		t.Errorf("total = %d users, want the admin and one registration", page.Total)
+	}
+
+	// The same key with another body is refused
+	rec := s.serve(idempotentRegister("key-1", `{"name":"Ada","email":"ada@example.com","password":"abc12345"}`))
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+
+	// Without the key the request is handled again
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/register", body, ""), http.StatusConflict)
+}
+
+func TestIdempotentRegisterReplaysErrors(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	body := `{"name":"Melisa","email":"melisa@example.com","password":"short"}`
+
+	expectStatus(t, s.serve(idempotentRegister("key-1", body)), http.StatusUnprocessableEntity)
+	expectStatus(t, s.serve(idempotentRegister("key-1", body)), http.StatusUnprocessableEntity)
+}
+
+func TestIdempotencyCacheExpires(t *testing.T) {
+	ic := NewIdempotencyCache(time.Minute)
+	hash := [32]byte{1}
+
+	if _, isNew := ic.reserve("key", hash); !isNew {
+		t.Fatal("first reserve isn't new")
+	}
+	ic.complete("key", http.StatusOK, echo.MIMEApplicationJSON, []byte("{}"))
+	if saved, isNew := ic.reserve("key", hash); isNew || !saved.done {
+		t.Fatalf("second reserve = %+v, %v, want the saved response", saved, isNew)
+	}
+
+	ic.entries["key"].expires = time.Now().Add(-time.Second)
+	if _, isNew := ic.reserve("key", hash); !isNew {
+		t.Error("an expired key was replayed")
+	}
+}
+
+func TestIdempotencyCacheIsBounded(t *testing.T) {
+	ic := NewIdempotencyCache(time.Hour)
+	for i := 0; i < maxIdempotencyKeys+10; i++ {
+		ic.reserve("key-"+strconv.Itoa(i), [32]byte{})
+	}
+	if len(ic.entries) > maxIdempotencyKeys {
+		t.Errorf("cache holds %d keys, want at most %d", len(ic.entries), maxIdempotencyKeys)
+	}
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Set an RFC 5988 Link header pointing at the first, previous, next and last
+// pages of a listing. The URLs keep the request's other query parameters.
+// There is no prev link on the first page and no next link on the last.
+func setPageLinks(c echo.Context, page, limit, total int) {
+	last := max(1, (total+limit-1)/limit)
+
+	req := c.Request()
+	base := url.URL{Scheme: c.Scheme(), Host: req.Host, Path: req.URL.Path}
+	query := req.URL.Query()
+	link := func(p int, rel string) string {
+		query.Set("page", strconv.Itoa(p))
+		query.Set("limit", strconv.Itoa(limit))
+		base.RawQuery = query.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, base.String(), rel)
+	}
+
+	links := []string{link(1, "first")}
+	if page > 1 {
+		links = append(links, link(min(page-1, last), "prev"))
+	}
+	if page < last {
+		links = append(links, link(page+1, "next"))
+	}
+	links = append(links, link(last, "last"))
+
+	c.Response().Header().Set("Link", strings.Join(links, ", "))
+}
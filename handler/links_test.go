@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+// One entry of a Link header
+var linkEntry = regexp.MustCompile(`<([^>]*)>; rel="(\w+)"`)
+
+// Map each rel of a Link header to its URL
+func parseLinks(header string) map[string]string {
+	links := map[string]string{}
+	for _, m := range linkEntry.FindAllStringSubmatch(header, -1) {
+		links[m[2]] = m[1]
+	}
+	return links
+}
+
+func TestPageLinks(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+	for i := 0; i < 4; i++ {
+		email := "user" + strconv.Itoa(i) + "@example.com"
+		if _, err := s.users.Create(context.Background(), model.User{Name: "user", Email: email, PasswordHash: "hash"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pageURL := func(page string) string {
+		return "http://example.com/api/v1/users?limit=2&page=" + page + "&sort=email"
+	}
+
+	tests := []struct {
+		page string
+		want map[string]string
+	}{
+		{"1", map[string]string{"first": pageURL("1"), "next": pageURL("2"), "last": pageURL("3")}},
+		{"2", map[string]string{"first": pageURL("1"), "prev": pageURL("1"), "next": pageURL("3"), "last": pageURL("3")}},
+		{"3", map[string]string{"first": pageURL("1"), "prev": pageURL("2"), "last": pageURL("3")}},
+	}
+	for _, tt := range tests {
+		rec := s.request(http.MethodGet, "/api/v1/users?sort=email&limit=2&page="+tt.page, "", token)
+		expectStatus(t, rec, http.StatusOK)
+		links := parseLinks(rec.Header().Get("Link"))
+		if len(links) != len(tt.want) {
+			t.Errorf("page %s: links %v, want %v", tt.page, links, tt.want)
+			continue
+		}
+		for rel, want := range tt.want {
+			if links[rel] != want {
+				t.Errorf("page %s: %s = %q, want %q", tt.page, rel, links[rel], want)
+			}
+		}
+	}
+}
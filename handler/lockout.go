@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Stop tracking this many emails at once, beyond it stale entries are dropped
+const maxTrackedLogins = 10000
+
+// Failed login state for one email
+type loginAttempt struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// LoginLockout locks an email for a cooldown after too many failed logins in a row
+type LoginLockout struct {
+	mu        sync.Mutex
+	attempts  map[string]*loginAttempt
+	threshold int
+	cooldown  time.Duration
+}
+
+// Create a lockout that locks after threshold consecutive failures
+func NewLoginLockout(threshold int, cooldown time.Duration) *LoginLockout {
+	return &LoginLockout{
+		attempts:  map[string]*loginAttempt{},
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// Locked reports whether the email is locked right now
+func (l *LoginLockout) Locked(email string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[store.NormalizeEmail(email)]
+	return ok && time.Now().Before(a.lockedUntil)
+}
+
+// Fail records a failed login and locks the email once the threshold is reached
+func (l *LoginLockout) Fail(email string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	email = store.NormalizeEmail(email)
+	a, ok := l.attempts[email]
+	if !ok {
+		if len(l.attempts) >= maxTrackedLogins {
+			l.prune(now)
+		}
+		a = &loginAttempt{}
+		l.attempts[email] = a
+	}
+
+	a.failures++
+	a.lastFailure = now
+	if a.failures >= l.threshold {
+		a.failures = 0
+		a.lockedUntil = now.Add(l.cooldown)
+	}
+}
+
+// Reset clears the failures after a successful login
+func (l *LoginLockout) Reset(email string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, store.NormalizeEmail(email))
+}
+
+// Drop entries that are not locked and have not failed within the cooldown
+func (l *LoginLockout) prune(now time.Time) {
+	for email, a := range l.attempts {
+		if now.After(a.lockedUntil) && now.Sub(a.lastFailure) > l.cooldown {
+			delete(l.attempts, email)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+)
+
+// Attempt a login and return the response status
+func loginStatus(s *testServer, email, password string) int {
+	return s.request(http.MethodPost, "/api/v1/login", `{"email":"`+email+`","password":"`+password+`"}`, "").Code
+}
+
+func TestLockoutAfterRepeatedFailures(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+
+	// LockoutThreshold is 5, the 5th failure locks the account
+	for i := 1; i <= 5; i++ {
+		if status := loginStatus(s, "melisa@example.com", "wrong1234"); status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d, want 401", i, status)
+		}
+	}
+	if status := loginStatus(s, "melisa@example.com", "wrong1234"); status != http.StatusLocked {
+		t.Errorf("6th attempt: status %d, want 423", status)
+	}
+
+	// The right password doesn't get through while locked
+	if status := loginStatus(s, "Melisa@Example.com", "abc12345"); status != http.StatusLocked {
+		t.Errorf("good password while locked: status %d, want 423", status)
+	}
+}
+
+func TestLockoutResetsAfterSuccess(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+
+	for i := 0; i < 4; i++ {
+		loginStatus(s, "melisa@example.com", "wrong1234")
+	}
+	if status := loginStatus(s, "melisa@example.com", "abc12345"); status != http.StatusOK {
+		t.Fatalf("good password before lockout: status %d, want 200", status)
+	}
+
+	// The counter started over, so four more failures still don't lock
+	for i := 0; i < 4; i++ {
+		loginStatus(s, "melisa@example.com", "wrong1234")
+	}
+	if status := loginStatus(s, "melisa@example.com", "abc12345"); status != http.StatusOK {
+		t.Errorf("good password after reset: status %d, want 200", status)
+	}
+}
+
+func TestLockoutIgnoresStoreOutages(t *testing.T) {
+	s, fs := newFailingTestServer(t)
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+
+	// Failed lookups answer 500 and aren't counted as failed logins
+	fs.down = true
+	for i := 0; i < 10; i++ {
+		if status := loginStatus(s, "melisa@example.com", "abc12345"); status != http.StatusInternalServerError {
+			t.Fatalf("login during outage: status %d, want 500", status)
+		}
+	}
+
+	fs.down = false
+	if status := loginStatus(s, "melisa@example.com", "abc12345"); status != http.StatusOK {
+		t.Errorf("login after outage: status %d, want 200", status)
+	}
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Echo context key holding the request's logger
+const loggerKey = "logger"
+
+// Attribute keys containing any of these are never written to the log
+var redactedKeys = []string{"password", "token", "secret", "authorization"}
+
+// Create a JSON logger that redacts password-like attributes
+func NewLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			key := strings.ToLower(a.Key)
+			for _, redacted := range redactedKeys {
+				if strings.Contains(key, redacted) {
+					return slog.String(a.Key, "[REDACTED]")
+				}
+			}
+			return a
+		},
+	}))
+}
+
+// Give every request an id, reusing the client's X-Request-ID when it sends one.
+// The id is echoed in the X-Request-ID response header and attached to the request's logger.
+func requestID(logger *slog.Logger) echo.MiddlewareFunc {
+	return middleware.RequestIDWithConfig(middleware.RequestIDConfig{
+		RequestIDHandler: func(c echo.Context, id string) {
+			c.Set(loggerKey, logger.With(slog.String("request_id", id)))
+			trace.SpanFromContext(c.Request().Context()).SetAttributes(attribute.String("request.id", id))
+		},
+	})
+}
+
+// Logger for the current request, tagged with its request id
+func requestLog(c echo.Context) *slog.Logger {
+	if logger, ok := c.Get(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Log one JSON line per request with its method, path, status, latency, request id and client IP.
+// Requests slower than slowThreshold also get a WARN line naming the route, except
+// streaming routes, which are slow by design. A zero threshold turns that off.
+func requestLogger(logger *slog.Logger, slowThreshold time.Duration) echo.MiddlewareFunc {
+	return middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		HandleError:  true, // let the error handler set the status before it is logged
+		LogMethod:    true,
+		LogURIPath:   true,
+		LogStatus:    true,
+		LogLatency:   true,
+		LogRequestID: true,
+		LogRemoteIP:  true,
+		LogError:     true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			level := slog.LevelInfo
+			attrs := []slog.Attr{
+				slog.String("method", v.Method),
+				slog.String("path", v.URIPath),
+				slog.Int("status", v.Status),
+				slog.Duration("latency", v.Latency),
+				slog.String("request_id", v.RequestID),
+				slog.String("remote_ip", v.RemoteIP),
+			}
+			if v.Status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			if v.Error != nil {
+				attrs = append(attrs, slog.String("error", v.Error.Error()))
+			}
+
+			logger.LogAttrs(context.Background(), level, "request", attrs...)
+
+			if slowThreshold > 0 && v.Latency > slowThreshold && !streamingRoutes[c.Path()] {
+				logger.LogAttrs(context.Background(), slog.LevelWarn, "slow request",
+					slog.String("method", v.Method),
+					slog.String("route", c.Path()),
+					slog.Duration("latency", v.Latency),
+					slog.Duration("threshold", slowThreshold),
+					slog.String("request_id", v.RequestID),
+				)
+			}
+			return nil
+		},
+	})
+}
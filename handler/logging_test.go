@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Serve the API from a fresh memory store, logging JSON lines to the returned buffer
+func newLoggedTestServer(t *testing.T) (*testServer, *bytes.Buffer) {
+	t.Helper()
+	var buf bytes.Buffer
+	users := store.NewMemoryUserStore()
+	return newTestServerWithLogger(t, testConfig(), users, users, NewLogger(&buf, slog.LevelInfo)), &buf
+}
+
+// The log lines with the message, decoded
+func logLines(t *testing.T, buf *bytes.Buffer, msg string) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q isn't JSON: %v", line, err)
+		}
+		if entry["msg"] == msg {
+			lines = append(lines, entry)
+		}
+	}
+	return lines
+}
+
+func TestRequestLogging(t *testing.T) {
+	s, buf := newLoggedTestServer(t)
+
+	expectStatus(t, s.request(http.MethodGet, "/healthz", "", ""), http.StatusOK)
+
+	lines := logLines(t, buf, "request")
+	if len(lines) != 1 {
+		t.Fatalf("got %d request lines, want 1: %s", len(lines), buf.String())
+	}
+	line := lines[0]
+	for _, key := range []string{"time", "level", "method", "path", "status", "latency", "request_id", "remote_ip"} {
+		if _, ok := line[key]; !ok {
+			t.Errorf("request line has no %q: %v", key, line)
+		}
+	}
+	if line["method"] != "GET" || line["path"] != "/healthz" || line["status"] != float64(http.StatusOK) || line["level"] != "INFO" {
+		t.Errorf("request line = %v", line)
+	}
+}
+
+func TestLoggerRedactsPasswords(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, slog.LevelInfo)
+
+	logger.Info("login", "email", "melisa@example.com", "password", "abc12345", "new_password", "abc12346", "refresh_token", "tok")
+	if out := buf.String(); strings.Contains(out, "abc1234") || strings.Contains(out, `"tok"`) {
+		t.Errorf("log line leaks a secret: %s", out)
+	}
+	if !strings.Contains(buf.String(), "melisa@example.com") {
+		t.Errorf("log line lost the email: %s", buf.String())
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, slog.LevelWarn)
+
+	logger.Info("hidden")
+	logger.Warn("shown")
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), "shown") {
+		t.Errorf("WARN logger wrote %s", buf.String())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	s, buf := newLoggedTestServer(t)
+
+	rec := s.request(http.MethodGet, "/healthz", "", "")
+	generated := rec.Header().Get(echo.HeaderXRequestID)
+	if generated == "" {
+		t.Fatal("response has no X-Request-ID")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/nowhere", nil)
+	req.Header.Set(echo.HeaderXRequestID, "client-id-1")
+	rec = s.serve(req)
+	if got := rec.Header().Get(echo.HeaderXRequestID); got != "client-id-1" {
+		t.Errorf("X-Request-ID = %q, want the client's", got)
+	}
+
+	lines := logLines(t, buf, "request")
+	if len(lines) != 2 || lines[0]["request_id"] != generated || lines[1]["request_id"] != "client-id-1" {
+		t.Errorf("request lines don't carry the ids: %v", lines)
+	}
+}
+
+func TestRequestIDInHandlerLogs(t *testing.T) {
+	s, buf := newLoggedTestServer(t)
+	s.e.GET("/fail", func(c echo.Context) error {
+		return errors.New("something broke")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/fail", nil)
+	req.Header.Set(echo.HeaderXRequestID, "client-id-2")
+	expectStatus(t, s.serve(req), http.StatusInternalServerError)
+
+	lines := logLines(t, buf, "request failed")
+	if len(lines) != 1 || lines[0]["request_id"] != "client-id-2" {
+		t.Errorf("handler log lines don't carry the request id: %s", buf)
+	}
+}
+
+func TestSlowRequestWarning(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := testConfig()
+	cfg.SlowRequestThreshold = 20 * time.Millisecond
+	users := store.NewMemoryUserStore()
+	s := newTestServerWithLogger(t, cfg, users, users, NewLogger(&buf, slog.LevelInfo))
+
+	slow := func(c echo.Context) error {
+		time.Sleep(40 * time.Millisecond)
+		return c.NoContent(http.StatusNoContent)
+	}
+	s.e.GET("/slow/:id", slow)
+	s.e.GET("/stream", slow)
+	streamingRoutes["/stream"] = true
+	t.Cleanup(func() { delete(streamingRoutes, "/stream") })
+
+	expectStatus(t, s.request(http.MethodGet, "/healthz", "", ""), http.StatusOK)
+	expectStatus(t, s.request(http.MethodGet, "/stream", "", ""), http.StatusNoContent)
+	if lines := logLines(t, &buf, "slow request"); len(lines) != 0 {
+		t.Fatalf("fast and streaming requests were logged as slow: %v", lines)
+	}
+
+	expectStatus(t, s.request(http.MethodGet, "/slow/42", "", ""), http.StatusNoContent)
+	lines := logLines(t, &buf, "slow request")
+	if len(lines) != 1 {
+		t.Fatalf("got %d slow request lines, want 1: %s", len(lines), buf.String())
+	}
+	if line := lines[0]; line["level"] != "WARN" || line["route"] != "/slow/:id" || line["method"] != "GET" {
+		t.Errorf("slow request line = %v", line)
+	}
+	if latency, _ := lines[0]["latency"].(float64); time.Duration(latency) < 40*time.Millisecond {
+		t.Errorf("latency = %v, want at least 40ms", time.Duration(latency))
+	}
+}
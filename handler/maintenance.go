@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Maintenance makes the API read-only while it is on. Reads keep working,
+// every other request gets 503 with the configured message.
+type Maintenance struct {
+	on         atomic.Bool
+	message    string
+	retryAfter string
+}
+
+// Create the switch, already on when on is set. Refused writes are told
+// to come back after retryAfter.
+func NewMaintenance(on bool, message string, retryAfter time.Duration) *Maintenance {
+	m := &Maintenance{message: message, retryAfter: strconv.Itoa(int(retryAfter.Seconds()))}
+	m.on.Store(on)
+	return m
+}
+
+// Whether writes are being refused
+func (m *Maintenance) Enabled() bool {
+	return m.on.Load()
+}
+
+// Turn maintenance mode on or off, logging the change
+func (m *Maintenance) Set(on bool) {
+	if m.on.Swap(on) == on {
+		return
+	}
+	if on {
+		slog.Warn("maintenance mode enabled, writes are refused")
+	} else {
+		slog.Info("maintenance mode disabled, writes are accepted")
+	}
+}
+
+// Flip maintenance mode, for the SIGUSR1 handler
+func (m *Maintenance) Toggle() {
+	m.Set(!m.Enabled())
+}
+
+// Refuse everything but GET, HEAD and OPTIONS while maintenance mode is on
+func (m *Maintenance) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			if !m.Enabled() {
+				return next(c)
+			}
+
+			c.Response().Header().Set("Retry-After", m.retryAfter)
+			return respondError(c, http.StatusServiceUnavailable, "maintenance", m.message)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaintenanceMode = true
+	cfg.MaintenanceMessage = "back soon"
+	cfg.MaintenanceRetryAfter = 2 * time.Minute
+	s := newTestServer(t, cfg)
+	token := s.adminToken(t)
+
+	rec := s.request(http.MethodPost, "/api/v1/register", `{"name":"Melisa","email":"melisa@example.com","password":"abc12345"}`, "")
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	if got := rec.Header().Get("Retry-After"); got != "120" {
+		t.Errorf("Retry-After = %q, want 120", got)
+	}
+	var body APIError
+	decode(t, rec, &body)
+	if body.Code != "maintenance" || body.Message != "back soon" {
+		t.Errorf("error = %+v, want the configured message", body)
+	}
+
+	// Reads keep working
+	if page := s.listUsers(t, token, ""); page.Total != 1 {
+		t.Errorf("total = %d, want the admin", page.Total)
+	}
+	expectStatus(t, s.request(http.MethodGet, "/healthz", "", ""), http.StatusOK)
+
+	// Switching it off at runtime lets writes through again, the switch logs to the default logger
+	discardDefaultLog(t)
+	s.Maintenance().Toggle()
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	s.Maintenance().Set(true)
+	expectStatus(t, s.request(http.MethodDelete, "/api/v1/users/missing", "", token), http.StatusServiceUnavailable)
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	// https://pkg.go.dev/github.com/prometheus/client_golang/prometheus
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics collects request counts, latencies and in-flight requests
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+}
+
+// Create the metrics in their own registry, along with the Go runtime and process metrics
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Number of HTTP requests by method, route and status.",
+		}, []string{"method", "path", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by method and route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "path"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Number of HTTP requests being served.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.latency,
+		m.inFlight,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Record every request. The route pattern (e.g. /users/:id) is used as the
+// path label rather than the raw URL, so ids don't create new series.
+func (m *Metrics) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			m.inFlight.Inc()
+			defer m.inFlight.Dec()
+
+			start := time.Now()
+			err := next(c)
+
+			path := c.Path()
+			if path == "" {
+				path = "unmatched"
+			}
+
+			// The error handler hasn't written the response yet, so take the status from the error
+			status := c.Response().Status
+			if err != nil {
+				status = http.StatusInternalServerError
+				var he *echo.HTTPError
+				if errors.As(err, &he) {
+					status = he.Code
+				}
+			}
+
+			m.requests.WithLabelValues(c.Request().Method, path, strconv.Itoa(status)).Inc()
+			m.latency.WithLabelValues(c.Request().Method, path).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// Report the slots a concurrency limiter has in use as a gauge
+func (m *Metrics) TrackConcurrency(l *ConcurrencyLimiter) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "http_concurrency_slots_in_use",
+		Help: "Number of requests holding a slot of the concurrency limit.",
+	}, func() float64 {
+		return float64(l.InUse())
+	}))
+}
+
+// Report the GET /users cache hits and misses as counters
+func (m *Metrics) TrackUsersCache(uc *UsersCache) {
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "users_cache_hits_total",
+			Help: "Number of GET /users pages served from the cache.",
+		}, func() float64 {
+			return float64(uc.Hits())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "users_cache_misses_total",
+			Help: "Number of GET /users pages fetched from the store.",
+		}, func() float64 {
+			return float64(uc.Misses())
+		}),
+	)
+}
+
+// Report whether the store breaker is open as a 0 or 1 gauge
+func (m *Metrics) TrackStoreBreaker(b *StoreBreaker) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "store_breaker_open",
+		Help: "1 while the store is unreachable and API requests are refused, 0 otherwise.",
+	}, func() float64 {
+		if b.Open() {
+			return 1
+		}
+		return 0
+	}))
+}
+
+// Serve the metrics in the Prometheus text format
+func (m *Metrics) Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
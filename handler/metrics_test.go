@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	cfg := testConfig()
+	cfg.MetricsEnabled = true
+	s := newTestServer(t, cfg)
+	token := s.adminToken(t)
+
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/missing", "", token), http.StatusNotFound)
+
+	rec := s.request(http.MethodGet, "/metrics", "", "")
+	expectStatus(t, rec, http.StatusOK)
+	out := rec.Body.String()
+	for _, want := range []string{
+		`http_requests_total{method="GET",path="/api/v1/users/:id",status="404"} 1`,
+		`http_request_duration_seconds_count{method="GET",path="/api/v1/users/:id"`,
+		"http_requests_in_flight",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("/metrics has no %s", want)
+		}
+	}
+
+	// Labels use the route, not the raw path
+	if strings.Contains(out, "/api/v1/users/missing") {
+		t.Error("/metrics labels a request with its raw path")
+	}
+}
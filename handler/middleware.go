@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// Routes taking multipart/form-data uploads. They enforce their own size limit
+// in place of BODY_LIMIT and aren't held to requireJSON.
+var uploadRoutes = map[string]bool{
+	"/api/v1/users/:id/avatar": true,
+}
+
+// Routes that stream their response and may run longer than the request timeout
+var streamingRoutes = map[string]bool{
+	"/api/v1/users.csv": true,
+}
+
+// Responses shorter than this are sent uncompressed, gzip wouldn't save anything
+const gzipMinLength = 1024
+
+// Gzip responses for clients that send Accept-Encoding: gzip. Streamed responses
+// are compressed as they are flushed. /metrics is skipped because the Prometheus
+// handler already compresses its output.
+func compress(level int) echo.MiddlewareFunc {
+	return middleware.GzipWithConfig(middleware.GzipConfig{
+		Level:     level,
+		MinLength: gzipMinLength,
+		Skipper: func(c echo.Context) bool {
+			return c.Path() == "/metrics"
+		},
+	})
+}
+
+// Reject POST, PUT and PATCH requests whose body isn't JSON with 415.
+// A charset parameter such as "application/json; charset=utf-8" is allowed,
+// and requests without a body, such as a restore, don't need a Content-Type.
+// Upload routes are skipped.
+func requireJSON() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch:
+			default:
+				return next(c)
+			}
+			if c.Request().ContentLength == 0 || uploadRoutes[c.Path()] {
+				return next(c)
+			}
+
+			mediaType, _, err := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+			if err != nil || mediaType != echo.MIMEApplicationJSON {
+				return respondError(c, http.StatusUnsupportedMediaType, "unsupported_media_type", "Content-Type must be application/json")
+			}
+			return next(c)
+		}
+	}
+}
+
+// Give each request a context deadline. Store calls watching the request context
+// stop when it expires and the client gets 503, from respondStoreError or from
+// the error handler here when a handler returns the error instead.
+// Streaming routes are left without a deadline.
+func requestTimeout(timeout time.Duration) echo.MiddlewareFunc {
+	return middleware.ContextTimeoutWithConfig(middleware.ContextTimeoutConfig{
+		Timeout: timeout,
+		Skipper: func(c echo.Context) bool {
+			return streamingRoutes[c.Path()]
+		},
+		ErrorHandler: func(err error, c echo.Context) error {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return respondError(c, http.StatusServiceUnavailable, "timeout", "request timed out")
+			}
+			return err
+		},
+	})
+}
@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// A memory store whose lookups by id hang until the context is done
+type slowStore struct {
+	*store.MemoryUserStore
+}
+
+func (s slowStore) GetByID(ctx context.Context, id string) (model.User, error) {
+	<-ctx.Done()
+	return model.User{}, ctx.Err()
+}
+
+func TestRequestTimeoutAnswers503(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequestTimeout = 50 * time.Millisecond
+	mem := store.NewMemoryUserStore()
+	s := newTestServerWithStore(t, cfg, slowStore{mem}, mem)
+	token := s.adminToken(t)
+
+	start := time.Now()
+	rec := s.request(http.MethodGet, "/api/v1/users/some-id", "", token)
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	var body APIError
+	decode(t, rec, &body)
+	if body.Code != "timeout" {
+		t.Errorf("code = %q, want timeout", body.Code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s, the deadline is %s", elapsed, cfg.RequestTimeout)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	cfg := testConfig()
+	cfg.AllowedOrigins = []string{"https://app.example.com"}
+	s := newTestServer(t, cfg)
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/api/v1/register", nil)
+		req.Header.Set(echo.HeaderOrigin, origin)
+		req.Header.Set(echo.HeaderAccessControlRequestMethod, http.MethodPost)
+		req.Header.Set(echo.HeaderAccessControlRequestHeaders, "Authorization, Content-Type")
+		return s.serve(req)
+	}
+
+	rec := preflight("https://app.example.com")
+	expectStatus(t, rec, http.StatusNoContent)
+	if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != "https://app.example.com" {
+		t.Errorf("allowed origin: Access-Control-Allow-Origin = %q", got)
+	}
+	allowHeaders := rec.Header().Get(echo.HeaderAccessControlAllowHeaders)
+	if !strings.Contains(allowHeaders, echo.HeaderAuthorization) || !strings.Contains(allowHeaders, echo.HeaderContentType) {
+		t.Errorf("Access-Control-Allow-Headers = %q", allowHeaders)
+	}
+
+	rec = preflight("https://evil.example.com")
+	if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != "" {
+		t.Errorf("disallowed origin: Access-Control-Allow-Origin = %q", got)
+	}
+}
+
+// Requests turned away by middleware still carry the CORS headers, so a
+// browser lets the page read why
+func TestCORSOnRejectedRequests(t *testing.T) {
+	discardDefaultLog(t)
+	cfg := testConfig()
+	cfg.AllowedOrigins = []string{"https://app.example.com"}
+	s := newTestServer(t, cfg)
+
+	send := func(contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/register", strings.NewReader(`{"name":"Melisa","email":"melisa@example.com","password":"abc12345"}`))
+		req.Header.Set(echo.HeaderOrigin, "https://app.example.com")
+		req.Header.Set(echo.HeaderContentType, contentType)
+		return s.serve(req)
+	}
+
+	rec := send(echo.MIMETextPlain)
+	expectStatus(t, rec, http.StatusUnsupportedMediaType)
+	if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != "https://app.example.com" {
+		t.Errorf("415: Access-Control-Allow-Origin = %q", got)
+	}
+
+	s.Maintenance().Set(true)
+	rec = send(echo.MIMEApplicationJSON)
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	if got := rec.Header().Get(echo.HeaderAccessControlAllowOrigin); got != "https://app.example.com" {
+		t.Errorf("maintenance 503: Access-Control-Allow-Origin = %q", got)
+	}
+}
+
+func TestRequireJSON(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	body := `{"name":"Melisa","email":"melisa@example.com","password":"abc12345"}`
+
+	send := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set(echo.HeaderContentType, contentType)
+		}
+		return s.serve(req)
+	}
+
+	expectStatus(t, send("/api/v1/register", echo.MIMEApplicationForm, "name=Melisa&email=melisa%40example.com&password=abc12345"), http.StatusUnsupportedMediaType)
+	expectStatus(t, send("/api/v1/login", echo.MIMETextPlain, `{"email":"melisa@example.com","password":"abc12345"}`), http.StatusUnsupportedMediaType)
+	expectStatus(t, send("/api/v1/register", "", body), http.StatusUnsupportedMediaType)
+	expectStatus(t, send("/api/v1/register", "application/json; charset=utf-8", body), http.StatusOK)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	cfg := testConfig()
+	cfg.RequestTimeout = 50 * time.Millisecond
+	s := newTestServer(t, cfg)
+	s.e.GET("/slow", func(c echo.Context) error {
+		ctx := c.Request().Context()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	start := time.Now()
+	rec := s.request(http.MethodGet, "/slow", "", "")
+	expectStatus(t, rec, http.StatusServiceUnavailable)
+	var body APIError
+	decode(t, rec, &body)
+	if body.Code != "timeout" {
+		t.Errorf("code = %q, want timeout", body.Code)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s, the deadline is %s", elapsed, cfg.RequestTimeout)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	cfg := testConfig()
+	cfg.GzipLevel = 6
+	s := newTestServer(t, cfg)
+	token := s.adminToken(t)
+	for i := 0; i < 30; i++ {
+		email := "user" + strconv.Itoa(i) + "@example.com"
+		if _, err := s.users.Create(context.Background(), model.User{Name: "user", Email: email, PasswordHash: "hash"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		if acceptEncoding != "" {
+			req.Header.Set(echo.HeaderAcceptEncoding, acceptEncoding)
+		}
+		return s.serve(req)
+	}
+
+	plain := get("/api/v1/users", "")
+	expectStatus(t, plain, http.StatusOK)
+	if plain.Header().Get(echo.HeaderContentEncoding) != "" {
+		t.Errorf("compressed without Accept-Encoding: %q", plain.Header().Get(echo.HeaderContentEncoding))
+	}
+
+	rec := get("/api/v1/users", "gzip")
+	expectStatus(t, rec, http.StatusOK)
+	if rec.Header().Get(echo.HeaderContentEncoding) != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get(echo.HeaderContentEncoding))
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != plain.Body.String() {
+		t.Error("decompressed body differs from the uncompressed one")
+	}
+
+	// Small responses aren't worth compressing
+	if rec := get("/healthz", "gzip"); rec.Header().Get(echo.HeaderContentEncoding) != "" {
+		t.Errorf("small response compressed: %q", rec.Header().Get(echo.HeaderContentEncoding))
+	}
+
+	// The streamed CSV is compressed once
+	rec = get("/api/v1/users.csv?all=true", "gzip")
+	expectStatus(t, rec, http.StatusOK)
+	zr, err = gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(zr).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV isn't readable after one gunzip: %v", err)
+	}
+	if len(rows) != 32 {
+		t.Errorf("got %d CSV rows, want a header and 31 users", len(rows))
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	s := newTestServer(t, testConfig())
+
+	rec := s.request(http.MethodGet, "/healthz", "", "")
+	for header, want := range map[string]string{
+		echo.HeaderXContentTypeOptions:     "nosniff",
+		echo.HeaderXFrameOptions:           "DENY",
+		echo.HeaderContentSecurityPolicy:   "default-src 'none'; frame-ancestors 'none'",
+		echo.HeaderStrictTransportSecurity: "",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	// HSTS is only sent over HTTPS, including through a TLS-terminating proxy
+	cfg := testConfig()
+	cfg.HSTSMaxAge = 31536000
+	cfg.FrameOptions = "SAMEORIGIN"
+	s = newTestServer(t, cfg)
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	req.Header.Set(echo.HeaderXForwardedProto, "https")
+	rec = s.serve(req)
+	if got := rec.Header().Get(echo.HeaderStrictTransportSecurity); got != "max-age=31536000; includeSubdomains" {
+		t.Errorf("HSTS over HTTPS = %q", got)
+	}
+	if got := rec.Header().Get(echo.HeaderXFrameOptions); got != "SAMEORIGIN" {
+		t.Errorf("X-Frame-Options = %q, want the configured SAMEORIGIN", got)
+	}
+	if got := s.request(http.MethodGet, "/healthz", "", "").Header().Get(echo.HeaderStrictTransportSecurity); got != "" {
+		t.Errorf("HSTS over HTTP = %q", got)
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Reported when a password is the local part of the user's email, e.g. "melisa" for melisa@example.com
+var errPasswordMatchesEmail = errors.New("must not match the email address")
+
+// Check a password has at least minLength characters and mixes letters and digits
+func validatePasswordStrength(pw string, minLength int) error {
+	if len([]rune(pw)) < minLength {
+		return fmt.Errorf("must be at least %d characters", minLength)
+	}
+
+	var hasLetter, hasDigit bool
+	for _, r := range pw {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return errors.New("must contain both letters and digits")
+	}
+
+	return nil
+}
+
+// Check the password policy, including that the password isn't the email's local part
+func checkPasswordPolicy(pw, email string, minLength int) error {
+	if err := validatePasswordStrength(pw, minLength); err != nil {
+		return err
+	}
+
+	local, _, _ := strings.Cut(email, "@")
+	if local != "" && strings.EqualFold(pw, local) {
+		return errPasswordMatchesEmail
+	}
+
+	return nil
+}
+
+// Validation for the `password` tag, the sibling Email field is used when present
+func validatePasswordField(minLength int) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		var email string
+		if f := fl.Parent().FieldByName("Email"); f.IsValid() {
+			email = f.String()
+		}
+		return checkPasswordPolicy(fl.Field().String(), email, minLength) == nil
+	}
+}
+
+// Describe why a password failed the `password` tag
+func passwordErrorMessage(pw string, minLength int) string {
+	if err := validatePasswordStrength(pw, minLength); err != nil {
+		return err.Error()
+	}
+	// Strong enough, so it was rejected for matching the email
+	return errPasswordMatchesEmail.Error()
+}
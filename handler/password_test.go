@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHashPasswordIsSalted(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	ctx := context.Background()
+
+	first, err := s.hashPassword(ctx, "abc12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.hashPassword(ctx, "abc12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("the same password hashed to %q twice", first)
+	}
+	if strings.Contains(first, "abc12345") {
+		t.Errorf("hash %q contains the password", first)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	ctx := context.Background()
+
+	hash, err := s.hashPassword(ctx, "abc12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkPassword(ctx, hash, "abc12345") {
+		t.Error("checkPassword rejected the hashed password")
+	}
+	if checkPassword(ctx, hash, "abc12346") {
+		t.Error("checkPassword accepted a different password")
+	}
+	if checkPassword(ctx, "not a hash", "abc12345") {
+		t.Error("checkPassword accepted an invalid hash")
+	}
+}
+
+func TestRegisterNeverReturnsThePassword(t *testing.T) {
+	s := newTestServer(t, testConfig())
+
+	rec := s.request(http.MethodPost, "/api/v1/register", `{"name":"Melisa","email":"melisa@example.com","password":"abc12345"}`, "")
+	expectStatus(t, rec, http.StatusOK)
+	if body := rec.Body.String(); strings.Contains(body, "abc12345") || strings.Contains(body, "password") || strings.Contains(body, "$2a$") {
+		t.Errorf("register response leaks the password: %s", body)
+	}
+}
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		password string
+		email    string
+		ok       bool
+	}{
+		{"abc12345", "melisa@example.com", true},
+		{"ünïcödé1", "melisa@example.com", true},
+		{"a1b2c3d4e5", "", true},
+		{"abc1234", "melisa@example.com", false},
+		{"abcdefgh", "melisa@example.com", false},
+		{"12345678", "melisa@example.com", false},
+		{"", "melisa@example.com", false},
+		{"melisa12", "melisa12@example.com", false},
+		{"Melisa12", "melisa12@example.com", false},
+		{"melisa12", "melisa@example.com", true},
+	}
+	for _, tt := range tests {
+		if err := checkPasswordPolicy(tt.password, tt.email, 8); (err == nil) != tt.ok {
+			t.Errorf("checkPasswordPolicy(%q, %q) = %v, want ok %v", tt.password, tt.email, err, tt.ok)
+		}
+	}
+
+	if err := checkPasswordPolicy("abc12345", "", 12); err == nil {
+		t.Error("an 8 character password passed a 12 character minimum")
+	}
+}
+
+func TestRegisterWeakPassword(t *testing.T) {
+	s := newTestServer(t, testConfig())
+
+	tests := []struct {
+		password string
+		message  string
+	}{
+		{"abc123", "must be at least 8 characters"},
+		{"abcdefgh", "must contain both letters and digits"},
+		{"melisa12", "must not match the email address"},
+	}
+	for _, tt := range tests {
+		rec := s.request(http.MethodPost, "/api/v1/register", `{"name":"Melisa","email":"melisa12@example.com","password":"`+tt.password+`"}`, "")
+		expectStatus(t, rec, http.StatusUnprocessableEntity)
+		var body APIError
+		decode(t, rec, &body)
+		if body.Fields["password"] != tt.message {
+			t.Errorf("%q: password error = %q, want %q", tt.password, body.Fields["password"], tt.message)
+		}
+	}
+}
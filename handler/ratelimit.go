@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+	// https://pkg.go.dev/golang.org/x/time/rate
+)
+
+// Limit each client IP to perMinute requests, allowing short bursts.
+// Every call returns a limiter with its own counters.
+func rateLimit(perMinute, burst int) echo.MiddlewareFunc {
+	limit := rate.Limit(float64(perMinute) / 60)
+
+	// Seconds until the next request is allowed once the burst is used up
+	retryAfter := strconv.Itoa(int(math.Ceil(60 / float64(perMinute))))
+
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		// Per-IP limiters are dropped after 3 minutes without requests
+		Store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:      limit,
+			Burst:     burst,
+			ExpiresIn: 3 * time.Minute,
+		}),
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			return c.RealIP(), nil
+		},
+		DenyHandler: func(c echo.Context, identifier string, err error) error {
+			c.Response().Header().Set("Retry-After", retryAfter)
+			return respondError(c, http.StatusTooManyRequests, "rate_limited", "too many requests")
+		},
+	})
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRateLimitLogin(t *testing.T) {
+	cfg := testConfig()
+	cfg.RateLimitPerMinute = 5
+	cfg.RateLimitBurst = 5
+	s := newTestServer(t, cfg)
+
+	body := `{"email":"melisa@example.com","password":"abc12345"}`
+	for i := 0; i < 5; i++ {
+		if rec := s.request(http.MethodPost, "/api/v1/login", body, ""); rec.Code == http.StatusTooManyRequests {
+			t.Fatalf("request %d was rate limited", i+1)
+		}
+	}
+
+	rec := s.request(http.MethodPost, "/api/v1/login", body, "")
+	expectStatus(t, rec, http.StatusTooManyRequests)
+	if got := rec.Header().Get("Retry-After"); got != "12" {
+		t.Errorf("Retry-After = %q, want 12", got)
+	}
+
+	// Other clients have their own limit
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.RemoteAddr = "198.51.100.7:4321"
+	if rec := s.serve(req); rec.Code == http.StatusTooManyRequests {
+		t.Error("another client was rate limited")
+	}
+
+	// Each endpoint counts separately
+	rec = s.request(http.MethodPost, "/api/v1/register", `{"name":"Melisa","email":"melisa@example.com","password":"abc12345"}`, "")
+	expectStatus(t, rec, http.StatusOK)
+}
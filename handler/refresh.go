@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Returned when a refresh token is unknown, expired or revoked
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+// One issued refresh token. Tokens rotated from the same login share a family.
+type refreshEntry struct {
+	userID  string
+	family  string
+	expires time.Time
+	used    bool
+}
+
+// RefreshTokens keeps issued refresh tokens server-side so they can be revoked.
+// Only a SHA-256 of each token is kept. Each refresh rotates the token; presenting
+// a token that was already rotated revokes its whole family, since it was likely stolen.
+type RefreshTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*refreshEntry // entries by token hash
+	ttl    time.Duration
+}
+
+// Create an empty set of refresh tokens valid for ttl
+func NewRefreshTokens(ttl time.Duration) *RefreshTokens {
+	return &RefreshTokens{
+		tokens: map[string]*refreshEntry{},
+		ttl:    ttl,
+	}
+}
+
+// Issue starts a new token family for the user, e.g. on login
+func (r *RefreshTokens) Issue(userID string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.issue(userID, uuid.NewString())
+}
+
+// Rotate exchanges a valid token for a new one and returns the user it belongs to
+func (r *RefreshTokens) Rotate(token string) (userID, newToken string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.tokens[hashToken(token)]
+	if !ok || time.Now().After(entry.expires) {
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	// Reuse of a rotated token: revoke every token of the family
+	if entry.used {
+		r.revokeFamily(entry.family)
+		return "", "", ErrInvalidRefreshToken
+	}
+
+	entry.used = true
+	newToken, err = r.issue(entry.userID, entry.family)
+	if err != nil {
+		return "", "", err
+	}
+	return entry.userID, newToken, nil
+}
+
+// Revoke invalidates the token and every token rotated from the same login
+func (r *RefreshTokens) Revoke(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.tokens[hashToken(token)]; ok {
+		r.revokeFamily(entry.family)
+	}
+}
+
+// RevokeUser invalidates every refresh token of the user, ending all their
+// sessions, e.g. once their password has changed
+func (r *RefreshTokens) RevokeUser(userID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, entry := range r.tokens {
+		if entry.userID == userID {
+			delete(r.tokens, hash)
+		}
+	}
+}
+
+// Issue a token in the given family, the caller holds the lock
+func (r *RefreshTokens) issue(userID, family string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	// Drop expired tokens so the map doesn't grow forever
+	now := time.Now()
+	for hash, entry := range r.tokens {
+		if now.After(entry.expires) {
+			delete(r.tokens, hash)
+		}
+	}
+
+	r.tokens[hashToken(token)] = &refreshEntry{
+		userID:  userID,
+		family:  family,
+		expires: now.Add(r.ttl),
+	}
+	return token, nil
+}
+
+// Remove every token of a family, the caller holds the lock
+func (r *RefreshTokens) revokeFamily(family string) {
+	for hash, entry := range r.tokens {
+		if entry.family == family {
+			delete(r.tokens, hash)
+		}
+	}
+}
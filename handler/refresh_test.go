@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Tokens returned by /login and /token/refresh
+type tokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Log in and return both tokens
+func (s *testServer) loginPair(t *testing.T, email, password string) tokenPair {
+	t.Helper()
+	rec := s.request(http.MethodPost, "/api/v1/login", `{"email":"`+email+`","password":"`+password+`"}`, "")
+	expectStatus(t, rec, http.StatusOK)
+	var pair tokenPair
+	decode(t, rec, &pair)
+	return pair
+}
+
+// Exchange a refresh token
+func (s *testServer) refresh(refreshToken string) *httptest.ResponseRecorder {
+	return s.request(http.MethodPost, "/api/v1/token/refresh", `{"refresh_token":"`+refreshToken+`"}`, "")
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	first := s.loginPair(t, "melisa@example.com", "abc12345")
+
+	rec := s.refresh(first.RefreshToken)
+	expectStatus(t, rec, http.StatusOK)
+	var second tokenPair
+	decode(t, rec, &second)
+	if second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh token wasn't rotated: %q", second.RefreshToken)
+	}
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/me", "", second.Token), http.StatusOK)
+	expectStatus(t, s.refresh(second.RefreshToken), http.StatusOK)
+	expectStatus(t, s.refresh("made-up"), http.StatusUnauthorized)
+}
+
+func TestRefreshTokenReuseRevokesSession(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	first := s.loginPair(t, "melisa@example.com", "abc12345")
+	other := s.loginPair(t, "melisa@example.com", "abc12345")
+
+	rec := s.refresh(first.RefreshToken)
+	expectStatus(t, rec, http.StatusOK)
+	var second tokenPair
+	decode(t, rec, &second)
+
+	// Reusing the rotated token ends the session, so the token it was rotated into stops working too
+	expectStatus(t, s.refresh(first.RefreshToken), http.StatusUnauthorized)
+	expectStatus(t, s.refresh(second.RefreshToken), http.StatusUnauthorized)
+
+	// Other logins are left alone
+	expectStatus(t, s.refresh(other.RefreshToken), http.StatusOK)
+}
+
+func TestLogoutRevokesRefreshToken(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	session := s.loginPair(t, "melisa@example.com", "abc12345")
+	other := s.loginPair(t, "melisa@example.com", "abc12345")
+
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/logout", `{"refresh_token":"`+session.RefreshToken+`"}`, ""), http.StatusNoContent)
+	expectStatus(t, s.refresh(session.RefreshToken), http.StatusUnauthorized)
+	expectStatus(t, s.refresh(other.RefreshToken), http.StatusOK)
+}
+
+func TestRefreshTokenOfDeletedUser(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	admin := s.adminToken(t)
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	session := s.loginPair(t, "melisa@example.com", "abc12345")
+
+	expectStatus(t, s.request(http.MethodDelete, "/api/v1/users/"+user.ID, "", admin), http.StatusNoContent)
+	expectStatus(t, s.refresh(session.RefreshToken), http.StatusUnauthorized)
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+// How long a password reset token stays valid
+var resetTokenTTL = 30 * time.Minute
+
+// One outstanding password reset
+type resetEntry struct {
+	userID  string
+	expires time.Time
+}
+
+// ResetTokens holds outstanding password reset tokens.
+// Only a SHA-256 of each token is kept, and a token works once.
+type ResetTokens struct {
+	mu     sync.Mutex
+	tokens map[string]resetEntry // entries by token hash
+}
+
+// Create an empty set of reset tokens
+func NewResetTokens() *ResetTokens {
+	return &ResetTokens{tokens: map[string]resetEntry{}}
+}
+
+// Issue a new random token for the user
+func (r *ResetTokens) Issue(userID string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Drop expired tokens so the map doesn't grow forever
+	now := time.Now()
+	for hash, entry := range r.tokens {
+		if now.After(entry.expires) {
+			delete(r.tokens, hash)
+		}
+	}
+
+	r.tokens[hashToken(token)] = resetEntry{userID: userID, expires: now.Add(resetTokenTTL)}
+	return token, nil
+}
+
+// Lookup returns the user a valid token was issued to, without using it up
+func (r *ResetTokens) Lookup(token string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.tokens[hashToken(token)]
+	if !ok || time.Now().After(entry.expires) {
+		return "", false
+	}
+	return entry.userID, true
+}
+
+// Consume uses up a token, it reports false if the token was already used or has expired
+func (r *ResetTokens) Consume(token string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash := hashToken(token)
+	entry, ok := r.tokens[hash]
+	if !ok {
+		return false
+	}
+	delete(r.tokens, hash)
+	return time.Now().Before(entry.expires)
+}
+
+// 32 random bytes, hex encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SHA-256 of a token, hex encoded
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Delivers a password reset token to the user, by email for instance
+type ResetSender func(user model.User, token string)
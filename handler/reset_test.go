@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+func TestRequestPasswordResetHidesAccounts(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+
+	known := s.request(http.MethodPost, "/api/v1/password/reset-request", `{"email":"melisa@example.com"}`, "")
+	unknown := s.request(http.MethodPost, "/api/v1/password/reset-request", `{"email":"nobody@example.com"}`, "")
+	expectStatus(t, known, http.StatusOK)
+	expectStatus(t, unknown, http.StatusOK)
+	if known.Body.String() != unknown.Body.String() {
+		t.Errorf("responses differ: %s and %s", known.Body, unknown.Body)
+	}
+}
+
+func TestResetTokenIsSentNotLogged(t *testing.T) {
+	var buf bytes.Buffer
+	users := store.NewMemoryUserStore()
+	s := newTestServerWithLogger(t, testConfig(), users, users, NewLogger(&buf, slog.LevelDebug))
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	reset := func() {
+		t.Helper()
+		expectStatus(t, s.request(http.MethodPost, "/api/v1/password/reset-request", `{"email":"melisa@example.com"}`, ""), http.StatusOK)
+	}
+
+	// Without a sender the request is only logged
+	reset()
+	if lines := logLines(t, &buf, "password reset requested but no reset sender is set"); len(lines) != 1 || lines[0]["user_id"] != user.ID {
+		t.Errorf("no log line for the unsent reset: %s", buf.String())
+	}
+
+	var sent []string
+	s.SetResetSender(func(to model.User, token string) {
+		if to.ID != user.ID {
+			t.Errorf("reset sent to %s, want %s", to.ID, user.ID)
+		}
+		sent = append(sent, token)
+	})
+	reset()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d reset tokens, want 1", len(sent))
+	}
+	if strings.Contains(buf.String(), sent[0]) {
+		t.Errorf("the reset token was logged: %s", buf.String())
+	}
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/password/reset", `{"token":"`+sent[0]+`","new_password":"new12345"}`, ""), http.StatusOK)
+}
+
+func TestResetPassword(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	token, err := s.resetTokens.Issue(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A weak password is refused without using up the token
+	rec := s.request(http.MethodPost, "/api/v1/password/reset", `{"token":"`+token+`","new_password":"weak"}`, "")
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+
+	body := `{"token":"` + token + `","new_password":"new12345"}`
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/password/reset", body, ""), http.StatusOK)
+	s.login(t, "melisa@example.com", "new12345")
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/login", `{"email":"melisa@example.com","password":"abc12345"}`, ""), http.StatusUnauthorized)
+
+	// The token only works once
+	rec = s.request(http.MethodPost, "/api/v1/password/reset", `{"token":"`+token+`","new_password":"other12345"}`, "")
+	expectStatus(t, rec, http.StatusBadRequest)
+	var apiErr APIError
+	decode(t, rec, &apiErr)
+	if apiErr.Code != "invalid_token" {
+		t.Errorf("code = %q, want invalid_token", apiErr.Code)
+	}
+
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/password/reset", `{"token":"made-up","new_password":"other12345"}`, ""), http.StatusBadRequest)
+}
+
+func TestResetTokensExpire(t *testing.T) {
+	tokens := NewResetTokens()
+	token, err := tokens.Issue("u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := tokens.Lookup(token); !ok || id != "u1" {
+		t.Fatalf("Lookup = %q, %v", id, ok)
+	}
+
+	// Age the token past its TTL
+	for hash, entry := range tokens.tokens {
+		entry.expires = entry.expires.Add(-2 * resetTokenTTL)
+		tokens.tokens[hash] = entry
+	}
+	if _, ok := tokens.Lookup(token); ok {
+		t.Error("Lookup accepted an expired token")
+	}
+	if tokens.Consume(token) {
+		t.Error("Consume accepted an expired token")
+	}
+}
+
+func TestResetPasswordEndsSessions(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	s.register(t, "Ada", "ada@example.com", "abc12345")
+	stolen := s.loginPair(t, "melisa@example.com", "abc12345")
+	other := s.loginPair(t, "ada@example.com", "abc12345")
+
+	token, err := s.resetTokens.Issue(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/password/reset", `{"token":"`+token+`","new_password":"new12345"}`, ""), http.StatusOK)
+
+	expectStatus(t, s.refresh(stolen.RefreshToken), http.StatusUnauthorized)
+	expectStatus(t, s.refresh(other.RefreshToken), http.StatusOK)
+
+	// Logging in with the new password starts a fresh session
+	expectStatus(t, s.refresh(s.loginPair(t, "melisa@example.com", "new12345").RefreshToken), http.StatusOK)
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	// https://pkg.go.dev/github.com/santhosh-tekuri/jsonschema/v6
+)
+
+// JSON Schemas request bodies can be checked against with JSON_SCHEMA_VALIDATION
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// Compile one of the embedded schemas, e.g. "register.json".
+// They ship with the binary, so one that doesn't compile is a bug and panics.
+func mustCompileSchema(name string) *jsonschema.Schema {
+	schema, err := compileSchema(name)
+	if err != nil {
+		panic("schemas/" + name + ": " + err.Error())
+	}
+	return schema
+}
+
+// Compile one of the embedded schemas
+func compileSchema(name string) (*jsonschema.Schema, error) {
+	f, err := schemaFiles.Open("schemas/" + name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	doc, err := jsonschema.UnmarshalJSON(f)
+	if err != nil {
+		return nil, err
+	}
+
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(name, doc); err != nil {
+		return nil, err
+	}
+	return c.Compile(name)
+}
+
+// Check the raw body against schema before it is bound, answering 422 with
+// the failing locations, e.g. {"/password": "maxLength: got 80, want 72"}.
+// Bodies that aren't JSON are passed on so the binder can explain what is wrong.
+// A nil schema checks nothing.
+func validateSchema(schema *jsonschema.Schema) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if schema == nil {
+			return next
+		}
+		return func(c echo.Context) error {
+			req := c.Request()
+			body, err := io.ReadAll(req.Body)
+			if err != nil {
+				return respondBindError(c, err)
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(body))
+			if err != nil {
+				return next(c)
+			}
+
+			var verr *jsonschema.ValidationError
+			if err := schema.Validate(doc); errors.As(err, &verr) {
+				return respondJSON(c, http.StatusUnprocessableEntity, APIError{
+					Code:    "schema_validation_failed",
+					Message: "Request body doesn't match the schema",
+					Fields:  schemaErrors(verr),
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+// Flatten a schema validation error into messages by JSON pointer, "/" being the body itself
+func schemaErrors(verr *jsonschema.ValidationError) map[string]string {
+	fields := map[string]string{}
+	for _, unit := range verr.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		location := unit.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		if _, ok := fields[location]; !ok {
+			fields[location] = unit.Error.String()
+		}
+	}
+	return fields
+}
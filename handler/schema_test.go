@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestRegisterSchemaValidation(t *testing.T) {
+	// A 6 character password is enough for the struct rules, not for the schema
+	body := `{"name":"Melisa","email":"melisa@example.com","password":"abc123"}`
+	cfg := testConfig()
+	cfg.PasswordMinLength = 6
+	expectStatus(t, newTestServer(t, cfg).request(http.MethodPost, "/api/v1/register", body, ""), http.StatusOK)
+
+	cfg.JSONSchemaValidation = true
+	s := newTestServer(t, cfg)
+	rec := s.request(http.MethodPost, "/api/v1/register", body, "")
+	expectStatus(t, rec, http.StatusUnprocessableEntity)
+	var apiErr APIError
+	decode(t, rec, &apiErr)
+	if apiErr.Code != "schema_validation_failed" || apiErr.Fields["/password"] == "" {
+		t.Errorf("error = %+v, want a schema error at /password", apiErr)
+	}
+	if users, _ := s.users.List(context.Background()); len(users) != 0 {
+		t.Errorf("got %d users after a schema failure, want 0", len(users))
+	}
+
+	// Bodies matching the schema go through as usual
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+}
+
+func TestEmbeddedSchemasCompile(t *testing.T) {
+	entries, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if _, err := compileSchema(entry.Name()); err != nil {
+			t.Errorf("%s: %v", entry.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Create an admin account unless a user with the email already exists.
+// Running it again on every startup is a no-op, an existing account is never changed.
+func (h *Handler) SeedAdmin(ctx context.Context, email, password string) error {
+	email = store.NormalizeEmail(email)
+	_, err := h.store.GetByEmail(ctx, email)
+	switch {
+	case err == nil:
+		slog.Info("admin seeding skipped, email already registered", "email", email)
+		return nil
+	case !errors.Is(err, store.ErrUserNotFound):
+		return err
+	}
+
+	if err := checkPasswordPolicy(password, email, h.cfg.PasswordMinLength); err != nil {
+		return err
+	}
+
+	user, err := h.createUser(ctx, model.User{
+		Name:     "admin",
+		Email:    email,
+		Password: password,
+		Verified: true,
+		Role:     model.RoleAdmin,
+	})
+	if err != nil {
+		// Another instance seeded it between the lookup and the insert
+		if errors.Is(err, store.ErrEmailExists) {
+			slog.Info("admin seeding skipped, email already registered", "email", email)
+			return nil
+		}
+		return err
+	}
+
+	h.audit(ctx, slog.Default(), actorSystem, store.AuditUserCreate, user.ID)
+	slog.Info("admin account seeded", "id", user.ID, "email", user.Email)
+	return nil
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+func TestSeedAdmin(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	ctx := context.Background()
+
+	if err := s.SeedAdmin(ctx, "Root@Example.com", "admin1234"); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := s.users.GetByEmail(ctx, "root@example.com")
+	if err != nil {
+		t.Fatalf("admin wasn't created: %v", err)
+	}
+	if admin.Role != model.RoleAdmin || !admin.Verified || !checkPassword(ctx, admin.PasswordHash, "admin1234") {
+		t.Errorf("seeded admin = %+v", admin)
+	}
+
+	// A second run, even with another password, changes nothing
+	if err := s.SeedAdmin(ctx, "root@example.com", "other1234"); err != nil {
+		t.Fatal(err)
+	}
+	users, err := s.users.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Errorf("got %d users after seeding twice, want 1", len(users))
+	}
+	again, _ := s.users.GetByEmail(ctx, "root@example.com")
+	if again.PasswordHash != admin.PasswordHash {
+		t.Error("seeding again changed the admin's password")
+	}
+	s.login(t, "root@example.com", "admin1234")
+}
+
+func TestSeedAdminRejectsWeakPassword(t *testing.T) {
+	s := newTestServer(t, testConfig())
+
+	if err := s.SeedAdmin(context.Background(), "root@example.com", "admin"); err == nil {
+		t.Error("seeded an admin with a weak password")
+	}
+	if users, _ := s.users.List(context.Background()); len(users) != 0 {
+		t.Errorf("got %d users, want none", len(users))
+	}
+}
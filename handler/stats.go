@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// How long GET /stats serves the same counts before asking the store again
+const statsCacheTTL = 10 * time.Second
+
+// Last counts returned by the store, shared by every /stats request
+type statsCache struct {
+	mu        sync.Mutex
+	stats     store.StatsResult
+	fetchedAt time.Time
+}
+
+// Return the cached counts, refreshing them from the store once they are older than the TTL
+func (sc *statsCache) get(ctx context.Context, userStore store.UserStore) (store.StatsResult, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if !sc.fetchedAt.IsZero() && time.Since(sc.fetchedAt) < statsCacheTTL {
+		return sc.stats, nil
+	}
+
+	stats, err := userStore.Stats(ctx)
+	if err != nil {
+		return store.StatsResult{}, err
+	}
+	sc.stats = stats
+	sc.fetchedAt = time.Now()
+	return stats, nil
+}
+
+// Counts of all users, verified and unverified users, and users per role.
+// The counts may be up to statsCacheTTL old.
+func (h *Handler) Stats(c echo.Context) error {
+	stats, err := h.stats.get(c.Request().Context(), h.store)
+	if err != nil {
+		return respondStoreError(c, err, "Could not compute stats")
+	}
+	return respondJSON(c, http.StatusOK, stats)
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Fetch GET /stats as an admin
+func (s *testServer) fetchStats(t *testing.T, token string) store.StatsResult {
+	t.Helper()
+	rec := s.request(http.MethodGet, "/api/v1/stats", "", token)
+	expectStatus(t, rec, http.StatusOK)
+	var stats store.StatsResult
+	decode(t, rec, &stats)
+	return stats
+}
+
+func TestStats(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+	melisa := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	s.register(t, "Ada", "ada@example.com", "abc12345")
+	if err := s.users.SetVerified(context.Background(), melisa.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := s.fetchStats(t, token)
+	if stats.Total != 3 || stats.Verified != 1 || stats.Unverified != 2 || stats.ByRole["admin"] != 1 || stats.ByRole["user"] != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// Counts are cached for a while
+	s.register(t, "Zeynep", "zeynep@example.com", "abc12345")
+	if again := s.fetchStats(t, token); again.Total != 3 {
+		t.Errorf("total = %d within the cache TTL, want the cached 3", again.Total)
+	}
+	s.stats.fetchedAt = s.stats.fetchedAt.Add(-statsCacheTTL)
+	if fresh := s.fetchStats(t, token); fresh.Total != 4 {
+		t.Errorf("total = %d after the cache TTL, want 4", fresh.Total)
+	}
+
+	userToken := s.login(t, "ada@example.com", "abc12345")
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/stats", "", userToken), http.StatusForbidden)
+}
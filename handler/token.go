@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	// https://pkg.go.dev/github.com/golang-jwt/jwt/v5
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+// Echo context keys holding the authenticated user's id and role
+const (
+	userIDKey   = "user_id"
+	userRoleKey = "user_role"
+)
+
+// How long an access token stays valid
+var tokenTTL = 15 * time.Minute
+
+// How long an email verification token stays valid
+var verifyTokenTTL = 24 * time.Hour
+
+// Purpose of an email verification token
+const purposeVerify = "verify"
+
+// Returned when a token was issued for another purpose, e.g. an access token used to verify an email
+var errWrongPurpose = errors.New("token has the wrong purpose")
+
+// Claims carried by an access token:
+//
+//	{
+//	  "sub":   "<user id>",
+//	  "email": "<user email>",
+//	  "role":  "<user role>",
+//	  "iat":   <issued at, unix seconds>,
+//	  "exp":   <expires at, unix seconds>
+//	}
+//
+// Single-purpose tokens, such as email verification, also set "purpose"
+// and can't be used as access tokens.
+type Claims struct {
+	Email   string `json:"email"`
+	Role    string `json:"role,omitempty"`
+	Purpose string `json:"purpose,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Create an access token for the user, signed with HS256 and JWT_SECRET
+func (h *Handler) generateToken(user model.User) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		Email: user.Email,
+		Role:  user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(tokenTTL)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(h.cfg.JWTSecret))
+}
+
+// Create a signed token for a single purpose, such as verifying an email
+func (h *Handler) generatePurposeToken(user model.User, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		Email:   user.Email,
+		Purpose: purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(h.cfg.JWTSecret))
+}
+
+// Parse a single-purpose token, rejecting tokens issued for anything else
+func (h *Handler) parsePurposeToken(tokenString, purpose string) (*Claims, error) {
+	claims, err := parseToken(tokenString, []byte(h.cfg.JWTSecret))
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, errWrongPurpose
+	}
+	return claims, nil
+}
+
+// Parse a token and verify its signature and expiry
+func parseToken(tokenString string, secret []byte) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// JWTAuth rejects requests without a valid "Authorization: Bearer <token>" header.
+// The user id and role from the token are stored in the context under userIDKey and userRoleKey.
+func JWTAuth(secret string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+
+			// Read the token from the Authorization header
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			if header == "" {
+				return respondError(c, http.StatusUnauthorized, "missing_token", "missing token")
+			}
+			tokenString, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok || tokenString == "" {
+				return respondError(c, http.StatusUnauthorized, "malformed_header", "malformed authorization header")
+			}
+
+			// Verify the token
+			claims, err := parseToken(tokenString, []byte(secret))
+			switch {
+			case errors.Is(err, jwt.ErrTokenExpired):
+				return respondError(c, http.StatusUnauthorized, "token_expired", "token expired")
+			case errors.Is(err, jwt.ErrTokenMalformed):
+				return respondError(c, http.StatusUnauthorized, "malformed_token", "malformed token")
+			case err != nil, claims.Purpose != "":
+				return respondError(c, http.StatusUnauthorized, "invalid_token", "invalid token")
+			}
+
+			c.Set(userIDKey, claims.Subject)
+			c.Set(userRoleKey, claims.Role)
+			return next(c)
+		}
+	}
+}
+
+// RequireSelfOrRole rejects users with 403 unless the :id route parameter is
+// their own id or their token carries the role. It must run after JWTAuth.
+func RequireSelfOrRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, _ := c.Get(userIDKey).(string)
+			userRole, _ := c.Get(userRoleKey).(string)
+			if userID != c.Param("id") && userRole != role {
+				return respondError(c, http.StatusForbidden, "forbidden", "insufficient permissions")
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireRole rejects users whose token doesn't carry the role with 403.
+// It must run after JWTAuth.
+func RequireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if userRole, _ := c.Get(userRoleKey).(string); userRole != role {
+				return respondError(c, http.StatusForbidden, "forbidden", "insufficient permissions")
+			}
+			return next(c)
+		}
+	}
+}
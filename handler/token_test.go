@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+func TestLoginIssuesValidToken(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+
+	rec := s.request(http.MethodPost, "/api/v1/login", `{"email":"melisa@example.com","password":"abc12345"}`, "")
+	expectStatus(t, rec, http.StatusOK)
+	var body struct {
+		Token     string `json:"token"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	decode(t, rec, &body)
+	if body.ExpiresIn != 900 {
+		t.Errorf("expires_in = %d, want 900", body.ExpiresIn)
+	}
+
+	claims, err := parseToken(body.Token, []byte(s.cfg.JWTSecret))
+	if err != nil {
+		t.Fatalf("token doesn't validate with the secret: %v", err)
+	}
+	if claims.Subject != user.ID || claims.Email != "melisa@example.com" {
+		t.Errorf("claims sub %q email %q, want %q %q", claims.Subject, claims.Email, user.ID, "melisa@example.com")
+	}
+	if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); ttl != tokenTTL {
+		t.Errorf("exp - iat = %v, want %v", ttl, tokenTTL)
+	}
+
+	if _, err := parseToken(body.Token, []byte("another-secret")); err == nil {
+		t.Error("token validated with another secret")
+	}
+}
+
+func TestGenerateTokenCarriesRole(t *testing.T) {
+	s := newTestServer(t, testConfig())
+
+	token := s.tokenFor(t, model.User{ID: "u1", Email: "admin@example.com", Role: model.RoleAdmin})
+	claims, err := parseToken(token, []byte(s.cfg.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Role != model.RoleAdmin || claims.Purpose != "" {
+		t.Errorf("role %q purpose %q, want %q and none", claims.Role, claims.Purpose, model.RoleAdmin)
+	}
+	if !claims.ExpiresAt.After(time.Now()) {
+		t.Errorf("token already expired at %v", claims.ExpiresAt)
+	}
+}
+
+func TestMe(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+
+	rec := s.request(http.MethodGet, "/api/v1/me", "", token)
+	expectStatus(t, rec, http.StatusOK)
+	var me model.UserResponse
+	decode(t, rec, &me)
+	if me.ID != user.ID || me.Name != "Melisa" || me.Email != "melisa@example.com" {
+		t.Errorf("me = %+v, want %+v", me, user)
+	}
+	if strings.Contains(rec.Body.String(), "password") {
+		t.Errorf("/me leaks the password: %s", rec.Body)
+	}
+
+	// Signed with the right secret but expired an hour ago
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID,
+			IssuedAt:  jwt.NewNumericDate(time.Now().Add(-2 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+		},
+	}).SignedString([]byte(s.cfg.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same token with the first character of its signature changed
+	sig := strings.LastIndex(token, ".") + 1
+	swap := "A"
+	if token[sig] == 'A' {
+		swap = "B"
+	}
+	tampered := token[:sig] + swap + token[sig+1:]
+
+	tests := []struct {
+		name   string
+		header string
+		code   string
+	}{
+		{"missing", "", "missing_token"},
+		{"not bearer", "Basic " + token, "malformed_header"},
+		{"malformed", "Bearer not-a-token", "malformed_token"},
+		{"expired", "Bearer " + expired, "token_expired"},
+		{"tampered", "Bearer " + tampered, "invalid_token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+			if tt.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, tt.header)
+			}
+			rec := s.serve(req)
+			expectStatus(t, rec, http.StatusUnauthorized)
+			var body APIError
+			decode(t, rec, &body)
+			if body.Code != tt.code {
+				t.Errorf("code = %q, want %q", body.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestAdminOnlyRoutes(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	admin := s.adminToken(t)
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+
+	// Login puts the role in the token
+	claims, err := parseToken(token, []byte(s.cfg.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Role != model.RoleUser {
+		t.Errorf("role = %q, want %q", claims.Role, model.RoleUser)
+	}
+
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users", "", token), http.StatusForbidden)
+	expectStatus(t, s.request(http.MethodDelete, "/api/v1/users/"+user.ID, "", token), http.StatusForbidden)
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users", "", ""), http.StatusUnauthorized)
+
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users", "", admin), http.StatusOK)
+	expectStatus(t, s.request(http.MethodDelete, "/api/v1/users/"+user.ID, "", admin), http.StatusNoContent)
+}
@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	// https://pkg.go.dev/go.opentelemetry.io/otel
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Name the service and its tracer report under
+const serviceName = "go-rest-api"
+
+// Tracer for the spans started here. It uses the global provider, so its
+// spans go nowhere until SetupTracing installs an exporter.
+var tracer = otel.Tracer("github.com/melisacar/go-rest-api.git/handler")
+
+// Export spans over OTLP/HTTP to the collector named by OTEL_EXPORTER_OTLP_ENDPOINT,
+// and accept trace context from incoming requests. The returned function flushes
+// the spans still buffered and should be called before exiting.
+func SetupTracing(ctx context.Context, build BuildInfo) (func(context.Context) error, error) {
+	// The exporter reads the endpoint, and any OTEL_EXPORTER_OTLP_* headers, itself
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override these
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", serviceName),
+			attribute.String("service.version", build.Version),
+		),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Start a child span of the one in ctx
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End a span, marking it failed when err is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// A lookup finding nothing is an answer, not a failed span
+func ignoreNotFound(err error) error {
+	if errors.Is(err, store.ErrUserNotFound) {
+		return nil
+	}
+	return err
+}
+
+// A UserStore that wraps every call in a span, so traces show the time
+// spent in the store apart from the handler
+type tracingStore struct {
+	store.UserStore
+}
+
+func (s tracingStore) Create(ctx context.Context, user model.User) (model.User, error) {
+	ctx, span := startSpan(ctx, "store.Create")
+	user, err := s.UserStore.Create(ctx, user)
+	endSpan(span, err)
+	return user, err
+}
+
+func (s tracingStore) GetByEmail(ctx context.Context, email string) (model.User, error) {
+	ctx, span := startSpan(ctx, "store.GetByEmail")
+	user, err := s.UserStore.GetByEmail(ctx, email)
+	endSpan(span, ignoreNotFound(err))
+	return user, err
+}
+
+func (s tracingStore) GetByID(ctx context.Context, id string) (model.User, error) {
+	ctx, span := startSpan(ctx, "store.GetByID", attribute.String("user.id", id))
+	user, err := s.UserStore.GetByID(ctx, id)
+	endSpan(span, ignoreNotFound(err))
+	return user, err
+}
+
+func (s tracingStore) EmailTaken(ctx context.Context, email string) (bool, error) {
+	ctx, span := startSpan(ctx, "store.EmailTaken")
+	taken, err := s.UserStore.EmailTaken(ctx, email)
+	endSpan(span, err)
+	return taken, err
+}
+
+func (s tracingStore) List(ctx context.Context) ([]model.User, error) {
+	ctx, span := startSpan(ctx, "store.List")
+	users, err := s.UserStore.List(ctx)
+	endSpan(span, err)
+	return users, err
+}
+
+func (s tracingStore) ListPaged(ctx context.Context, sort store.UserSort, includeDeleted bool, offset, limit int) ([]model.User, int, error) {
+	ctx, span := startSpan(ctx, "store.ListPaged", attribute.Int("offset", offset), attribute.Int("limit", limit))
+	users, total, err := s.UserStore.ListPaged(ctx, sort, includeDeleted, offset, limit)
+	endSpan(span, err)
+	return users, total, err
+}
+
+func (s tracingStore) ListAfter(ctx context.Context, after store.UserCursor, includeDeleted bool, limit int) ([]model.User, error) {
+	ctx, span := startSpan(ctx, "store.ListAfter", attribute.Int("limit", limit))
+	users, err := s.UserStore.ListAfter(ctx, after, includeDeleted, limit)
+	endSpan(span, err)
+	return users, err
+}
+
+func (s tracingStore) Search(ctx context.Context, query, role string, includeDeleted bool, offset, limit int) ([]model.User, int, error) {
+	ctx, span := startSpan(ctx, "store.Search", attribute.Int("offset", offset), attribute.Int("limit", limit))
+	users, total, err := s.UserStore.Search(ctx, query, role, includeDeleted, offset, limit)
+	endSpan(span, err)
+	return users, total, err
+}
+
+func (s tracingStore) Update(ctx context.Context, id string, user model.User) (model.User, error) {
+	ctx, span := startSpan(ctx, "store.Update", attribute.String("user.id", id))
+	user, err := s.UserStore.Update(ctx, id, user)
+	endSpan(span, err)
+	return user, err
+}
+
+func (s tracingStore) SetVerified(ctx context.Context, id string) error {
+	ctx, span := startSpan(ctx, "store.SetVerified", attribute.String("user.id", id))
+	err := s.UserStore.SetVerified(ctx, id)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracingStore) UpdatePassword(ctx context.Context, id, passwordHash string) error {
+	ctx, span := startSpan(ctx, "store.UpdatePassword", attribute.String("user.id", id))
+	err := s.UserStore.UpdatePassword(ctx, id, passwordHash)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracingStore) SetLastLogin(ctx context.Context, id string, at time.Time) error {
+	ctx, span := startSpan(ctx, "store.SetLastLogin", attribute.String("user.id", id))
+	err := s.UserStore.SetLastLogin(ctx, id, at)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracingStore) SetAvatar(ctx context.Context, id, path string) error {
+	ctx, span := startSpan(ctx, "store.SetAvatar", attribute.String("user.id", id))
+	err := s.UserStore.SetAvatar(ctx, id, path)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracingStore) Delete(ctx context.Context, id string) error {
+	ctx, span := startSpan(ctx, "store.Delete", attribute.String("user.id", id))
+	err := s.UserStore.Delete(ctx, id)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracingStore) SoftDelete(ctx context.Context, id string) error {
+	ctx, span := startSpan(ctx, "store.SoftDelete", attribute.String("user.id", id))
+	err := s.UserStore.SoftDelete(ctx, id)
+	endSpan(span, err)
+	return err
+}
+
+func (s tracingStore) DeleteMany(ctx context.Context, ids []string, soft bool) ([]bool, error) {
+	ctx, span := startSpan(ctx, "store.DeleteMany", attribute.Int("count", len(ids)))
+	deleted, err := s.UserStore.DeleteMany(ctx, ids, soft)
+	endSpan(span, err)
+	return deleted, err
+}
+
+func (s tracingStore) Restore(ctx context.Context, id string) (model.User, error) {
+	ctx, span := startSpan(ctx, "store.Restore", attribute.String("user.id", id))
+	user, err := s.UserStore.Restore(ctx, id)
+	endSpan(span, err)
+	return user, err
+}
+
+func (s tracingStore) Stats(ctx context.Context) (store.StatsResult, error) {
+	ctx, span := startSpan(ctx, "store.Stats")
+	stats, err := s.UserStore.Stats(ctx)
+	endSpan(span, err)
+	return stats, err
+}
+
+func (s tracingStore) Ping(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "store.Ping")
+	err := s.UserStore.Ping(ctx)
+	endSpan(span, err)
+	return err
+}
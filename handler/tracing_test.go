@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Provider installed globally for the tests. The package tracer binds to the
+// first global provider, so tests share this one and add their own recorders.
+var (
+	testProviderOnce sync.Once
+	testProvider     *sdktrace.TracerProvider
+)
+
+// Record the spans ended until the test finishes
+func recordSpans(tb testing.TB) *tracetest.SpanRecorder {
+	tb.Helper()
+	testProviderOnce.Do(func() {
+		testProvider = sdktrace.NewTracerProvider()
+		otel.SetTracerProvider(testProvider)
+	})
+
+	recorder := tracetest.NewSpanRecorder()
+	testProvider.RegisterSpanProcessor(recorder)
+	tb.Cleanup(func() { testProvider.UnregisterSpanProcessor(recorder) })
+	return recorder
+}
+
+// The recorded spans with the name
+func spansNamed(recorder *tracetest.SpanRecorder, name string) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+func TestRegisterSpans(t *testing.T) {
+	spans := recordSpans(t)
+	cfg := testConfig()
+	cfg.OTLPEndpoint = "http://localhost:4318" // turns tracing on, nothing is exported in tests
+	s := newTestServer(t, cfg)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/register", strings.NewReader(`{"name":"Melisa","email":"melisa@example.com","password":"abc12345"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderXRequestID, "trace-me")
+	expectStatus(t, s.serve(req), http.StatusOK)
+
+	roots := spansNamed(spans, "POST /api/v1/register")
+	if len(roots) != 1 {
+		t.Fatalf("got %d request spans, want 1: %v", len(roots), spans.Ended())
+	}
+	root := roots[0]
+	if root.Parent().IsValid() {
+		t.Errorf("request span has a parent %v", root.Parent().SpanID())
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, attr := range root.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	if got := attrs["request.id"].AsString(); got != "trace-me" {
+		t.Errorf("request.id = %q, want trace-me", got)
+	}
+	if got := attrs["http.route"].AsString(); got != "/api/v1/register" {
+		t.Errorf("http.route = %q", got)
+	}
+
+	// Hashing and storing the user are children of the request, in that order
+	var children []string
+	for _, span := range spans.Ended() {
+		if span.Parent().SpanID() == root.SpanContext().SpanID() {
+			if span.SpanContext().TraceID() != root.SpanContext().TraceID() {
+				t.Errorf("%s is in another trace", span.Name())
+			}
+			children = append(children, span.Name())
+		}
+	}
+	if want := []string{"bcrypt.Hash", "store.Create"}; !slices.Equal(children, want) {
+		t.Errorf("child spans = %v, want %v", children, want)
+	}
+}
+
+func TestNoRequestSpansWithoutTracing(t *testing.T) {
+	spans := recordSpans(t)
+	s := newTestServer(t, testConfig())
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+
+	if got := spansNamed(spans, "POST /api/v1/register"); len(got) != 0 {
+		t.Errorf("got %d request spans with tracing off", len(got))
+	}
+	if got := spansNamed(spans, "store.Create"); len(got) != 0 {
+		t.Errorf("got %d store spans with tracing off", len(got))
+	}
+}
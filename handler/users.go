@@ -0,0 +1,430 @@
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Largest batch accepted by POST /users/bulk
+const maxBulkUsers = 1000
+
+// Largest batch accepted by POST /users/batch-delete
+const maxBatchDeleteIDs = 1000
+
+// Batch delete request body
+type BatchDeleteRequest struct {
+	IDs []string `json:"ids" validate:"required,min=1"`
+}
+
+// Outcome of one id in a batch delete
+type BatchDeleteResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status"` // "deleted" or "not_found"
+}
+
+// Outcome of one user in a bulk registration
+type BulkResult struct {
+	Email  string            `json:"email"`
+	Status string            `json:"status"` // "created" or "error"
+	ID     string            `json:"id,omitempty"`
+	Error  string            `json:"error,omitempty"`
+	Fields map[string]string `json:"errors,omitempty"`
+}
+
+// Update request body, omitted fields keep their current value.
+// The password can't be changed here.
+type UpdateUserRequest struct {
+	Name  string `json:"name" validate:"max=100"`
+	Email string `json:"email" validate:"omitempty,max=254,email"`
+}
+
+// Partial update body for PATCH. A nil field was omitted and is left unchanged,
+// a non-nil one is validated and applied, so "" can't slip through as "no change".
+type UserPatch struct {
+	Name  *string `json:"name" validate:"omitnil,min=1,max=100"`
+	Email *string `json:"email" validate:"omitnil,max=254,email"`
+}
+
+// List users one page at a time, in the order given by ?sort= and ?order=,
+// or by cursor when ?cursor= is given. Soft-deleted users are only listed
+// with ?include_deleted=true. Offset pages are cached for USERS_CACHE_TTL,
+// cursor pages always come from the store.
+func (h *Handler) ListUsers(c echo.Context) error {
+	if c.QueryParams().Has("cursor") {
+		return h.listUsersByCursor(c)
+	}
+
+	sort, err := parseSort(c)
+	if err != nil {
+		return respondError(c, http.StatusBadRequest, "invalid_sort", err.Error())
+	}
+
+	page, limit := parsePagination(c)
+	fetch := func() ([]model.User, int, error) {
+		return h.store.ListPaged(c.Request().Context(), sort, includeDeleted(c), (page-1)*limit, limit)
+	}
+	var (
+		users []model.User
+		total int
+	)
+	if h.usersCache != nil {
+		users, total, err = h.usersCache.get(c.QueryString(), fetch)
+	} else {
+		users, total, err = fetch()
+	}
+	if err != nil {
+		return respondStoreError(c, err, "Could not list users")
+	}
+
+	data := make([]model.UserResponse, 0, len(users))
+	for _, user := range users {
+		data = append(data, model.NewUserResponse(user))
+	}
+	setPageLinks(c, page, limit, total)
+	return respondJSON(c, http.StatusOK, map[string]interface{}{
+		"data":  data,
+		"page":  page,
+		"limit": limit,
+		"total": total,
+	})
+}
+
+// Search users by a case-insensitive substring of their name or email
+// with ?q=, optionally only those with the role given by ?role=
+func (h *Handler) SearchUsers(c echo.Context) error {
+	role := c.QueryParam("role")
+	if role != "" && role != model.RoleUser && role != model.RoleAdmin {
+		return respondFieldError(c, "role", "oneof")
+	}
+
+	page, limit := parsePagination(c)
+	users, total, err := h.store.Search(c.Request().Context(), strings.TrimSpace(c.QueryParam("q")), role, includeDeleted(c), (page-1)*limit, limit)
+	if err != nil {
+		return respondStoreError(c, err, "Could not search users")
+	}
+
+	data := make([]model.UserResponse, 0, len(users))
+	for _, user := range users {
+		data = append(data, model.NewUserResponse(user))
+	}
+	setPageLinks(c, page, limit, total)
+	return respondJSON(c, http.StatusOK, map[string]interface{}{
+		"data":  data,
+		"page":  page,
+		"limit": limit,
+		"total": total,
+	})
+}
+
+// Export users as CSV, the page given by ?page= and ?limit= or every user with ?all=true.
+// Rows are written a page at a time so large stores aren't held in memory.
+func (h *Handler) ExportUsersCSV(c echo.Context) error {
+	page, limit := parsePagination(c)
+	all := c.QueryParam("all") == "true"
+
+	// Read the first page before the headers go out, so a store failure can still get an error response
+	offset := (page - 1) * limit
+	if all {
+		offset, limit = 0, maxLimit
+	}
+	users, total, err := h.store.ListPaged(c.Request().Context(), store.DefaultUserSort, false, offset, limit)
+	if err != nil {
+		return respondStoreError(c, err, "Could not export users")
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	res.Header().Set(echo.HeaderContentDisposition, `attachment; filename="users.csv"`)
+	res.WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(res)
+	if err := w.Write([]string{"name", "email"}); err != nil {
+		return err
+	}
+
+	for {
+		for _, user := range users {
+			if err := w.Write([]string{user.Name, user.Email}); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		res.Flush()
+
+		offset += limit
+		if !all || len(users) == 0 || offset >= total {
+			return nil
+		}
+
+		// Past the headers, a failure can only cut the file short
+		users, total, err = h.store.ListPaged(c.Request().Context(), store.DefaultUserSort, false, offset, limit)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Register many users at once. Each user succeeds or fails on its own,
+// so the response is 207 with one result per user, in request order.
+func (h *Handler) BulkRegister(c echo.Context) error {
+	var users []model.User
+	if err := c.Bind(&users); err != nil {
+		return respondBindError(c, err)
+	}
+	if len(users) > maxBulkUsers {
+		return respondError(c, http.StatusRequestEntityTooLarge, "batch_too_large",
+			"at most "+strconv.Itoa(maxBulkUsers)+" users per request")
+	}
+
+	results := make([]BulkResult, 0, len(users))
+	for _, user := range users {
+		result := BulkResult{Email: store.NormalizeEmail(user.Email)}
+
+		if err := c.Validate(&user); err != nil {
+			result.Status = "error"
+			result.Error = "Validation failed"
+			result.Fields = h.validationErrors(err)
+			results = append(results, result)
+			continue
+		}
+
+		if !h.emailDomainAllowed(user.Email) {
+			result.Status = "error"
+			result.Error = "Validation failed"
+			result.Fields = map[string]string{"email": "domain " + emailDomain(user.Email) + " is not allowed"}
+			results = append(results, result)
+			continue
+		}
+
+		created, err := h.createUser(c.Request().Context(), user)
+		switch {
+		case errors.Is(err, store.ErrEmailExists):
+			result.Status = "error"
+			result.Error = "email already registered"
+		case err != nil:
+			result.Status = "error"
+			result.Error = "Could not register user"
+		default:
+			result.Status = "created"
+			result.ID = created.ID
+			h.auditRequest(c, store.AuditUserCreate, created.ID)
+			h.webhook.Send(requestLog(c), eventUserRegistered, created)
+		}
+		results = append(results, result)
+	}
+
+	return respondJSON(c, http.StatusMultiStatus, results)
+}
+
+// Get a single user by id
+func (h *Handler) GetUser(c echo.Context) error {
+	user, err := h.store.GetByID(c.Request().Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+	case err != nil:
+		return respondStoreError(c, err, "Could not load user")
+	}
+
+	// Let clients holding the current version skip the body
+	etag := userETag(user)
+	c.Response().Header().Set("ETag", etag)
+	if inm := c.Request().Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return respondJSON(c, http.StatusOK, model.NewUserResponse(user))
+}
+
+// Update a user's name and/or email
+func (h *Handler) UpdateUser(c echo.Context) error {
+
+	// Bind and validate the update
+	var req UpdateUserRequest
+	if err := c.Bind(&req); err != nil {
+		return respondBindError(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return h.respondValidationError(c, err)
+	}
+
+	// Apply the provided fields on top of the current user
+	user, err := h.store.GetByID(c.Request().Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+	case err != nil:
+		return respondStoreError(c, err, "Could not load user")
+	}
+
+	// Refuse to overwrite a version the client hasn't seen
+	if im := c.Request().Header.Get("If-Match"); im != "" && !etagMatches(im, userETag(user)) {
+		return respondError(c, http.StatusPreconditionFailed, "precondition_failed", "user has changed since it was fetched")
+	}
+
+	// A new email is held to ALLOWED_EMAIL_DOMAINS like a registration
+	if req.Email != "" && !h.emailChangeAllowed(user, req.Email) {
+		return respondFieldError(c, "email", "domain "+emailDomain(req.Email)+" is not allowed")
+	}
+
+	if req.Name != "" {
+		user.Name = req.Name
+	}
+	if req.Email != "" {
+		user.Email = store.NormalizeEmail(req.Email)
+	}
+
+	user, err = h.store.Update(c.Request().Context(), user.ID, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrUserNotFound):
+			return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+		case errors.Is(err, store.ErrEmailExists):
+			return respondError(c, http.StatusConflict, "email_exists", "email already registered")
+		}
+		return respondStoreError(c, err, "Could not update user")
+	}
+	h.auditRequest(c, store.AuditUserUpdate, user.ID)
+
+	c.Response().Header().Set("ETag", userETag(user))
+	return respondJSON(c, http.StatusOK, model.NewUserResponse(user))
+}
+
+// Change only the fields present in the body
+func (h *Handler) PatchUser(c echo.Context) error {
+
+	// Bind and validate the provided fields
+	var patch UserPatch
+	if err := c.Bind(&patch); err != nil {
+		return respondBindError(c, err)
+	}
+	if err := c.Validate(&patch); err != nil {
+		return h.respondValidationError(c, err)
+	}
+
+	user, err := h.store.GetByID(c.Request().Context(), c.Param("id"))
+	switch {
+	case errors.Is(err, store.ErrUserNotFound):
+		return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+	case err != nil:
+		return respondStoreError(c, err, "Could not load user")
+	}
+
+	// Refuse to overwrite a version the client hasn't seen
+	if im := c.Request().Header.Get("If-Match"); im != "" && !etagMatches(im, userETag(user)) {
+		return respondError(c, http.StatusPreconditionFailed, "precondition_failed", "user has changed since it was fetched")
+	}
+
+	// A body of {} is a valid patch that changes nothing, so nothing is written
+	if patch == (UserPatch{}) {
+		c.Response().Header().Set("ETag", userETag(user))
+		return respondJSON(c, http.StatusOK, model.NewUserResponse(user))
+	}
+
+	// A new email is held to ALLOWED_EMAIL_DOMAINS like a registration
+	if patch.Email != nil && !h.emailChangeAllowed(user, *patch.Email) {
+		return respondFieldError(c, "email", "domain "+emailDomain(*patch.Email)+" is not allowed")
+	}
+
+	if patch.Name != nil {
+		user.Name = *patch.Name
+	}
+	if patch.Email != nil {
+		user.Email = store.NormalizeEmail(*patch.Email)
+	}
+
+	user, err = h.store.Update(c.Request().Context(), user.ID, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrUserNotFound):
+			return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+		case errors.Is(err, store.ErrEmailExists):
+			return respondError(c, http.StatusConflict, "email_exists", "email already registered")
+		}
+		return respondStoreError(c, err, "Could not update user")
+	}
+	h.auditRequest(c, store.AuditUserUpdate, user.ID)
+
+	c.Response().Header().Set("ETag", userETag(user))
+	return respondJSON(c, http.StatusOK, model.NewUserResponse(user))
+}
+
+// Delete a user, or only mark them deleted when SOFT_DELETE is enabled
+func (h *Handler) DeleteUser(c echo.Context) error {
+	remove := h.store.Delete
+	if h.cfg.SoftDelete {
+		remove = h.store.SoftDelete
+	}
+
+	if err := remove(c.Request().Context(), c.Param("id")); err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+		}
+		return respondStoreError(c, err, "Could not delete user")
+	}
+	h.auditRequest(c, store.AuditUserDelete, c.Param("id"))
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Delete many users at once, or only mark them deleted when SOFT_DELETE is enabled.
+// The response is 207 with one result per id, in request order.
+func (h *Handler) BatchDeleteUsers(c echo.Context) error {
+	var req BatchDeleteRequest
+	if err := c.Bind(&req); err != nil {
+		return respondBindError(c, err)
+	}
+	if err := c.Validate(&req); err != nil {
+		return h.respondValidationError(c, err)
+	}
+	if len(req.IDs) > maxBatchDeleteIDs {
+		return respondError(c, http.StatusRequestEntityTooLarge, "batch_too_large",
+			"at most "+strconv.Itoa(maxBatchDeleteIDs)+" ids per request")
+	}
+
+	deleted, err := h.store.DeleteMany(c.Request().Context(), req.IDs, h.cfg.SoftDelete)
+	if err != nil {
+		return respondStoreError(c, err, "Could not delete users")
+	}
+
+	results := make([]BatchDeleteResult, len(req.IDs))
+	for i, id := range req.IDs {
+		results[i] = BatchDeleteResult{ID: id, Status: "deleted"}
+		if !deleted[i] {
+			results[i].Status = "not_found"
+			continue
+		}
+		h.auditRequest(c, store.AuditUserDelete, id)
+	}
+	return respondJSON(c, http.StatusMultiStatus, results)
+}
+
+// Undo a soft delete
+func (h *Handler) RestoreUser(c echo.Context) error {
+	user, err := h.store.Restore(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			return respondError(c, http.StatusNotFound, "user_not_found", "user not found")
+		}
+		return respondStoreError(c, err, "Could not restore user")
+	}
+	h.auditRequest(c, store.AuditUserRestore, user.ID)
+
+	return respondJSON(c, http.StatusOK, model.NewUserResponse(user))
+}
+
+// Whether ?include_deleted=true asks for soft-deleted users too
+func includeDeleted(c echo.Context) bool {
+	return c.QueryParam("include_deleted") == "true"
+}
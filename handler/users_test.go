@@ -0,0 +1,704 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Returned by failingStore
+var errStoreDown = errors.New("store is down")
+
+// A memory store whose reads and pings fail while down is set
+type failingStore struct {
+	*store.MemoryUserStore
+	down bool
+}
+
+func (s *failingStore) GetByID(ctx context.Context, id string) (model.User, error) {
+	if s.down {
+		return model.User{}, errStoreDown
+	}
+	return s.MemoryUserStore.GetByID(ctx, id)
+}
+
+func (s *failingStore) GetByEmail(ctx context.Context, email string) (model.User, error) {
+	if s.down {
+		return model.User{}, errStoreDown
+	}
+	return s.MemoryUserStore.GetByEmail(ctx, email)
+}
+
+func (s *failingStore) ListPaged(ctx context.Context, sort store.UserSort, includeDeleted bool, offset, limit int) ([]model.User, int, error) {
+	if s.down {
+		return nil, 0, errStoreDown
+	}
+	return s.MemoryUserStore.ListPaged(ctx, sort, includeDeleted, offset, limit)
+}
+
+func (s *failingStore) Ping(ctx context.Context) error {
+	if s.down {
+		return errStoreDown
+	}
+	return s.MemoryUserStore.Ping(ctx)
+}
+
+// Serve the API from a memory store that can be made to fail
+func newFailingTestServer(t *testing.T) (*testServer, *failingStore) {
+	t.Helper()
+	mem := store.NewMemoryUserStore()
+	fs := &failingStore{MemoryUserStore: mem}
+	return newTestServerWithStore(t, testConfig(), fs, mem), fs
+}
+
+func TestGetUserStoreFailureIsNotNotFound(t *testing.T) {
+	s, fs := newFailingTestServer(t)
+	token := s.adminToken(t)
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+
+	fs.down = true
+	rec := s.request(http.MethodGet, "/api/v1/users/"+user.ID, "", token)
+	expectStatus(t, rec, http.StatusInternalServerError)
+
+	fs.down = false
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/"+user.ID, "", token), http.StatusOK)
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/missing", "", token), http.StatusNotFound)
+}
+
+func TestListUsersStoreFailureIsNotEmptyPage(t *testing.T) {
+	s, fs := newFailingTestServer(t)
+	token := s.adminToken(t)
+
+	fs.down = true
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users", "", token), http.StatusInternalServerError)
+}
+
+func TestSoftDeletedUserIsHiddenButRestorable(t *testing.T) {
+	cfg := testConfig()
+	cfg.SoftDelete = true
+	s := newTestServer(t, cfg)
+	token := s.adminToken(t)
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+
+	expectStatus(t, s.request(http.MethodDelete, "/api/v1/users/"+user.ID, "", token), http.StatusNoContent)
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/"+user.ID, "", token), http.StatusNotFound)
+
+	var page struct {
+		Total int `json:"total"`
+	}
+	decode(t, s.request(http.MethodGet, "/api/v1/users", "", token), &page)
+	if page.Total != 1 {
+		t.Errorf("total = %d, want only the admin", page.Total)
+	}
+	decode(t, s.request(http.MethodGet, "/api/v1/users?include_deleted=true", "", token), &page)
+	if page.Total != 2 {
+		t.Errorf("total with include_deleted = %d, want 2", page.Total)
+	}
+
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/users/"+user.ID+"/restore", "", token), http.StatusOK)
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/"+user.ID, "", token), http.StatusOK)
+}
+
+func TestDryRunRejectsSoftDeletedEmail(t *testing.T) {
+	cfg := testConfig()
+	cfg.SoftDelete = true
+	s := newTestServer(t, cfg)
+	token := s.adminToken(t)
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	expectStatus(t, s.request(http.MethodDelete, "/api/v1/users/"+user.ID, "", token), http.StatusNoContent)
+
+	// The deleted user still holds the email, so the dry run agrees with /register
+	body := `{"name":"Melisa","email":"melisa@example.com","password":"abc12345"}`
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/register/validate", body, ""), http.StatusConflict)
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/register", body, ""), http.StatusConflict)
+}
+
+func TestGetUser(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	admin := s.adminToken(t)
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	other := s.register(t, "Other", "other@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+
+	rec := s.request(http.MethodGet, "/api/v1/users/"+user.ID, "", token)
+	expectStatus(t, rec, http.StatusOK)
+	var got model.UserResponse
+	decode(t, rec, &got)
+	if got.ID != user.ID || got.Email != "melisa@example.com" {
+		t.Errorf("got %+v, want %+v", got, user)
+	}
+
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/"+user.ID, "", admin), http.StatusOK)
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/missing", "", admin), http.StatusNotFound)
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/"+user.ID, "", ""), http.StatusUnauthorized)
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/"+other.ID, "", token), http.StatusForbidden)
+}
+
+func TestGetAvatarRequiresSelfOrAdmin(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	other := s.register(t, "Other", "other@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/"+user.ID+"/avatar", "", ""), http.StatusUnauthorized)
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/"+other.ID+"/avatar", "", token), http.StatusForbidden)
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/"+user.ID+"/avatar", "", token), http.StatusNotFound)
+}
+
+func TestUpdateUser(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	admin := s.adminToken(t)
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	other := s.register(t, "Other", "other@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+	path := "/api/v1/users/" + user.ID
+
+	rec := s.request(http.MethodPut, path, `{"name":"Melisa Acar","email":"Melisa.Acar@example.com"}`, token)
+	expectStatus(t, rec, http.StatusOK)
+	var got model.UserResponse
+	decode(t, rec, &got)
+	if got.Name != "Melisa Acar" || got.Email != "melisa.acar@example.com" {
+		t.Errorf("got name %q email %q", got.Name, got.Email)
+	}
+
+	expectStatus(t, s.request(http.MethodPut, path, `{"name":"Anonymous"}`, ""), http.StatusUnauthorized)
+	expectStatus(t, s.request(http.MethodPut, "/api/v1/users/"+other.ID, `{"name":"Taken over"}`, token), http.StatusForbidden)
+	expectStatus(t, s.request(http.MethodPut, path, `{"email":"other@example.com"}`, token), http.StatusConflict)
+	expectStatus(t, s.request(http.MethodPut, path, `{"password":"new12345"}`, token), http.StatusBadRequest)
+	expectStatus(t, s.request(http.MethodPut, "/api/v1/users/missing", `{"name":"Nobody"}`, admin), http.StatusNotFound)
+	expectStatus(t, s.request(http.MethodPut, "/api/v1/users/"+other.ID, `{"name":"Renamed"}`, admin), http.StatusOK)
+
+	// The password is unchanged
+	s.login(t, "melisa.acar@example.com", "abc12345")
+}
+
+func TestPatchUser(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	other := s.register(t, "Other", "other@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+	path := "/api/v1/users/" + user.ID
+
+	patch := func(body string) model.UserResponse {
+		t.Helper()
+		rec := s.request(http.MethodPatch, path, body, token)
+		expectStatus(t, rec, http.StatusOK)
+		var got model.UserResponse
+		decode(t, rec, &got)
+		return got
+	}
+
+	if got := patch(`{"name":"Melisa Acar"}`); got.Name != "Melisa Acar" || got.Email != "melisa@example.com" {
+		t.Errorf("patching the name: got name %q email %q", got.Name, got.Email)
+	}
+	if got := patch(`{"email":"acar@example.com"}`); got.Name != "Melisa Acar" || got.Email != "acar@example.com" {
+		t.Errorf("patching the email: got name %q email %q", got.Name, got.Email)
+	}
+	if got := patch(`{}`); got.Name != "Melisa Acar" || got.Email != "acar@example.com" {
+		t.Errorf("empty patch: got name %q email %q", got.Name, got.Email)
+	}
+
+	expectStatus(t, s.request(http.MethodPatch, path, `{"name":""}`, token), http.StatusUnprocessableEntity)
+	expectStatus(t, s.request(http.MethodPatch, path, `{"email":"other@example.com"}`, token), http.StatusConflict)
+	expectStatus(t, s.request(http.MethodPatch, path, `{"name":"Anonymous"}`, ""), http.StatusUnauthorized)
+	expectStatus(t, s.request(http.MethodPatch, "/api/v1/users/"+other.ID, `{"name":"Taken over"}`, token), http.StatusForbidden)
+}
+
+// Body of an offset page of GET /users
+type userPage struct {
+	Data  []model.UserResponse `json:"data"`
+	Page  int                  `json:"page"`
+	Limit int                  `json:"limit"`
+	Total int                  `json:"total"`
+}
+
+// Fetch a page of GET /users with the query string, as an admin
+func (s *testServer) listUsers(t *testing.T, token, query string) userPage {
+	t.Helper()
+	rec := s.request(http.MethodGet, "/api/v1/users"+query, "", token)
+	expectStatus(t, rec, http.StatusOK)
+	var page userPage
+	decode(t, rec, &page)
+	return page
+}
+
+func TestListUsers(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+	s.register(t, "zeynep", "zeynep@example.com", "abc12345")
+	s.register(t, "melisa", "melisa@example.com", "abc12345")
+
+	rec := s.request(http.MethodGet, "/api/v1/users", "", token)
+	expectStatus(t, rec, http.StatusOK)
+	if strings.Contains(rec.Body.String(), "password") {
+		t.Errorf("listing leaks passwords: %s", rec.Body)
+	}
+
+	var page userPage
+	decode(t, rec, &page)
+	var names []string
+	for _, user := range page.Data {
+		names = append(names, user.Name)
+	}
+	if want := []string{"admin", "melisa", "zeynep"}; !slices.Equal(names, want) {
+		t.Errorf("names = %v, want %v sorted by name", names, want)
+	}
+}
+
+func TestListUsersPagination(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+	s.register(t, "melisa", "melisa@example.com", "abc12345")
+	s.register(t, "zeynep", "zeynep@example.com", "abc12345")
+
+	page := s.listUsers(t, token, "?page=2&limit=2")
+	if page.Page != 2 || page.Limit != 2 || page.Total != 3 || len(page.Data) != 1 || page.Data[0].Name != "zeynep" {
+		t.Errorf("page 2 = %+v", page)
+	}
+
+	// A page beyond the end is empty, not an error
+	page = s.listUsers(t, token, "?page=5&limit=2")
+	if page.Total != 3 || page.Data == nil || len(page.Data) != 0 {
+		t.Errorf("page beyond the end = %+v", page)
+	}
+
+	tests := []struct {
+		query       string
+		page, limit int
+	}{
+		{"", 1, 20},
+		{"?page=0&limit=0", 1, 20},
+		{"?page=-1&limit=-5", 1, 20},
+		{"?page=abc&limit=xyz", 1, 20},
+		{"?limit=1000", 1, 100},
+	}
+	for _, tt := range tests {
+		page := s.listUsers(t, token, tt.query)
+		if page.Page != tt.page || page.Limit != tt.limit {
+			t.Errorf("%q: page %d limit %d, want %d %d", tt.query, page.Page, page.Limit, tt.page, tt.limit)
+		}
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	path := "/api/v1/users/" + user.ID
+
+	expectStatus(t, s.request(http.MethodDelete, path, "", token), http.StatusNoContent)
+	expectStatus(t, s.request(http.MethodGet, path, "", token), http.StatusNotFound)
+	expectStatus(t, s.request(http.MethodDelete, path, "", token), http.StatusNotFound)
+}
+
+func TestBulkRegister(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+	s.register(t, "Melisa", "melisa@example.com", "abc12345")
+
+	batch := `[
+		{"name":"Ada","email":"ada@example.com","password":"abc12345"},
+		{"name":"Ada again","email":"ADA@example.com","password":"abc12345"},
+		{"name":"Melisa","email":"melisa@example.com","password":"abc12345"},
+		{"name":"Weak","email":"weak@example.com","password":"short"},
+		{"name":"Zeynep","email":"zeynep@example.com","password":"abc12345"}
+	]`
+	rec := s.request(http.MethodPost, "/api/v1/users/bulk", batch, token)
+	expectStatus(t, rec, http.StatusMultiStatus)
+	var results []BulkResult
+	decode(t, rec, &results)
+
+	want := []struct{ email, status, err string }{
+		{"ada@example.com", "created", ""},
+		{"ada@example.com", "error", "email already registered"},
+		{"melisa@example.com", "error", "email already registered"},
+		{"weak@example.com", "error", "Validation failed"},
+		{"zeynep@example.com", "created", ""},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d: %s", len(results), len(want), rec.Body)
+	}
+	for i, w := range want {
+		r := results[i]
+		if r.Email != w.email || r.Status != w.status || r.Error != w.err {
+			t.Errorf("result %d = %+v, want %+v", i, r, w)
+		}
+	}
+	if results[3].Fields["password"] == "" {
+		t.Errorf("weak password result has no field error: %+v", results[3])
+	}
+	if page := s.listUsers(t, token, ""); page.Total != 4 {
+		t.Errorf("total = %d users, want 4", page.Total)
+	}
+}
+
+func TestBulkRegisterTooLarge(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+
+	users := make([]string, maxBulkUsers+1)
+	for i := range users {
+		users[i] = `{"name":"User","email":"user` + strconv.Itoa(i) + `@example.com","password":"abc12345"}`
+	}
+	rec := s.request(http.MethodPost, "/api/v1/users/bulk", "["+strings.Join(users, ",")+"]", token)
+	expectStatus(t, rec, http.StatusRequestEntityTooLarge)
+	if page := s.listUsers(t, token, ""); page.Total != 1 {
+		t.Errorf("total = %d users, want only the admin", page.Total)
+	}
+}
+
+// Fetch GET /users.csv with the query string and parse it
+func (s *testServer) exportCSV(t *testing.T, token, query string) [][]string {
+	t.Helper()
+	rec := s.request(http.MethodGet, "/api/v1/users.csv"+query, "", token)
+	expectStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := rec.Header().Get(echo.HeaderContentDisposition); !strings.HasPrefix(cd, "attachment") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	return rows
+}
+
+func TestExportUsersCSV(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+	s.register(t, "melisa", "melisa@example.com", "abc12345")
+	s.register(t, "zeynep, jr", "zeynep@example.com", "abc12345")
+
+	rows := s.exportCSV(t, token, "")
+	want := [][]string{
+		{"name", "email"},
+		{"admin", "admin@example.com"},
+		{"melisa", "melisa@example.com"},
+		{"zeynep, jr", "zeynep@example.com"},
+	}
+	if !slices.EqualFunc(rows, want, slices.Equal) {
+		t.Errorf("rows = %q, want %q", rows, want)
+	}
+
+	rows = s.exportCSV(t, token, "?page=2&limit=2")
+	if len(rows) != 2 || rows[1][1] != "zeynep@example.com" {
+		t.Errorf("page 2 rows = %q", rows)
+	}
+}
+
+func TestExportAllUsersCSV(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+
+	// More users than fit on one page of the export
+	for i := 0; i < maxLimit+50; i++ {
+		email := "user" + strconv.Itoa(i) + "@example.com"
+		if _, err := s.users.Create(context.Background(), model.User{Name: "user", Email: email, PasswordHash: "hash"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows := s.exportCSV(t, token, "?all=true")
+	if len(rows) != maxLimit+52 {
+		t.Errorf("got %d rows, want a header and %d users", len(rows), maxLimit+51)
+	}
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users.csv?all=true", "", ""), http.StatusUnauthorized)
+}
+
+func TestSearchUsers(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+	s.register(t, "Melisa Acar", "acar@example.com", "abc12345")
+	s.register(t, "Ada", "ada.melisa@example.org", "abc12345")
+	s.register(t, "Zeynep", "zeynep@example.com", "abc12345")
+	if _, err := s.users.Create(context.Background(), model.User{Name: "Melisa Root", Email: "root@example.com", PasswordHash: "hash", Role: model.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(query string) []string {
+		t.Helper()
+		rec := s.request(http.MethodGet, "/api/v1/users/search"+query, "", token)
+		expectStatus(t, rec, http.StatusOK)
+		var page userPage
+		decode(t, rec, &page)
+		if page.Total != len(page.Data) {
+			t.Errorf("%s: total %d for %d users", query, page.Total, len(page.Data))
+		}
+		var emails []string
+		for _, user := range page.Data {
+			emails = append(emails, user.Email)
+		}
+		return emails
+	}
+
+	// Sorted by name, where the seeded "admin" comes after the capitalized names
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"?q=MELISA", []string{"ada.melisa@example.org", "acar@example.com", "root@example.com"}},
+		{"?q=example.org", []string{"ada.melisa@example.org"}},
+		{"?q=melisa&role=admin", []string{"root@example.com"}},
+		{"?q=melisa&role=user", []string{"ada.melisa@example.org", "acar@example.com"}},
+		{"?role=admin", []string{"root@example.com", "admin@example.com"}},
+		{"?q=nobody", nil},
+		{"", []string{"ada.melisa@example.org", "acar@example.com", "root@example.com", "zeynep@example.com", "admin@example.com"}},
+	}
+	for _, tt := range tests {
+		if got := search(tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users/search?role=owner", "", token), http.StatusUnprocessableEntity)
+}
+
+func TestUserTimestamps(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+
+	time.Sleep(time.Millisecond)
+	rec := s.request(http.MethodPatch, "/api/v1/users/"+user.ID, `{"name":"Melisa Acar"}`, token)
+	expectStatus(t, rec, http.StatusOK)
+	var body map[string]string
+	decode(t, rec, &body)
+	createdAt, err := time.Parse(time.RFC3339, body["created_at"])
+	if err != nil {
+		t.Fatalf("created_at %q isn't RFC3339: %v", body["created_at"], err)
+	}
+	updatedAt, err := time.Parse(time.RFC3339, body["updated_at"])
+	if err != nil {
+		t.Fatalf("updated_at %q isn't RFC3339: %v", body["updated_at"], err)
+	}
+	if !createdAt.Equal(user.CreatedAt) || !updatedAt.After(user.UpdatedAt) {
+		t.Errorf("after a patch created_at %v updated_at %v, registered with %v %v", createdAt, updatedAt, user.CreatedAt, user.UpdatedAt)
+	}
+}
+
+func TestListUsersSort(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+	s.register(t, "melisa", "zz@example.com", "abc12345")
+
+	page := s.listUsers(t, token, "?sort=email&order=desc")
+	if len(page.Data) != 2 || page.Data[0].Email != "zz@example.com" {
+		t.Errorf("emails descending = %+v", page.Data)
+	}
+
+	for _, query := range []string{"?sort=password_hash", "?order=sideways", "?sort=name&order=up"} {
+		rec := s.request(http.MethodGet, "/api/v1/users"+query, "", token)
+		expectStatus(t, rec, http.StatusBadRequest)
+	}
+}
+
+func TestConditionalGetUser(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+	path := "/api/v1/users/" + user.ID
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return s.serve(req)
+	}
+
+	rec := get("")
+	expectStatus(t, rec, http.StatusOK)
+	etag := rec.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("ETag = %q, want a weak validator", etag)
+	}
+
+	rec = get(etag)
+	expectStatus(t, rec, http.StatusNotModified)
+	if rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+		t.Errorf("304 body %q ETag %q", rec.Body, rec.Header().Get("ETag"))
+	}
+	expectStatus(t, get(`W/"stale", `+etag), http.StatusNotModified)
+	expectStatus(t, get(`W/"stale"`), http.StatusOK)
+
+	// A change gives a new ETag
+	expectStatus(t, s.request(http.MethodPatch, path, `{"name":"Melisa Acar"}`, token), http.StatusOK)
+	rec = get(etag)
+	expectStatus(t, rec, http.StatusOK)
+	if rec.Header().Get("ETag") == etag {
+		t.Error("ETag didn't change with the user")
+	}
+}
+
+func TestIfMatchPreventsLostUpdates(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+	token := s.login(t, "melisa@example.com", "abc12345")
+	path := "/api/v1/users/" + user.ID
+
+	etag := s.request(http.MethodGet, path, "", token).Header().Get("ETag")
+	write := func(method, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		req.Header.Set("If-Match", ifMatch)
+		return s.serve(req)
+	}
+
+	// The first client's write goes through and changes the ETag
+	rec := write(http.MethodPatch, `{"name":"First"}`, etag)
+	expectStatus(t, rec, http.StatusOK)
+	newETag := rec.Header().Get("ETag")
+
+	// A second client still holding the old ETag is refused
+	expectStatus(t, write(http.MethodPatch, `{"name":"Second"}`, etag), http.StatusPreconditionFailed)
+	expectStatus(t, write(http.MethodPut, `{"name":"Second"}`, etag), http.StatusPreconditionFailed)
+	expectStatus(t, write(http.MethodPut, `{"name":"Second"}`, newETag), http.StatusOK)
+
+	stored, _ := s.users.GetByID(context.Background(), user.ID)
+	if stored.Name != "Second" {
+		t.Errorf("name = %q, want the write with the current ETag", stored.Name)
+	}
+}
+
+func TestListUsersByCursor(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+	for _, name := range []string{"melisa", "zeynep", "ada", "eve"} {
+		s.register(t, name, name+"@example.com", "abc12345")
+	}
+	type cursorPage struct {
+		Data       []model.UserResponse `json:"data"`
+		Limit      int                  `json:"limit"`
+		NextCursor string               `json:"next_cursor"`
+	}
+
+	// Walk two at a time, signing someone up after the first page
+	var names []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatalf("cursor paging doesn't end, got %v", names)
+		}
+		rec := s.request(http.MethodGet, "/api/v1/users?limit=2&cursor="+cursor, "", token)
+		expectStatus(t, rec, http.StatusOK)
+		var page cursorPage
+		decode(t, rec, &page)
+		if page.Limit != 2 || len(page.Data) > 2 {
+			t.Fatalf("page = %+v", page)
+		}
+		for _, user := range page.Data {
+			names = append(names, user.Name)
+		}
+		if pages == 0 {
+			s.register(t, "sena", "sena@example.com", "abc12345")
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	// Oldest first, each user exactly once, the late signup at the end
+	if want := []string{"admin", "melisa", "zeynep", "ada", "eve", "sena"}; !slices.Equal(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users?cursor=not-a-cursor", "", token), http.StatusBadRequest)
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users?cursor=&page=2", "", token), http.StatusBadRequest)
+
+	// Offset paging keeps working alongside
+	if page := s.listUsers(t, token, "?page=1&limit=2"); page.Total != 6 || len(page.Data) != 2 {
+		t.Errorf("offset page = %+v", page)
+	}
+}
+
+func TestBatchDeleteUsers(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	token := s.adminToken(t)
+	melisa := s.register(t, "melisa", "melisa@example.com", "abc12345")
+	zeynep := s.register(t, "zeynep", "zeynep@example.com", "abc12345")
+	ada := s.register(t, "ada", "ada@example.com", "abc12345")
+
+	rec := s.request(http.MethodPost, "/api/v1/users/batch-delete", `{"ids":["`+melisa.ID+`","missing","`+zeynep.ID+`"]}`, token)
+	expectStatus(t, rec, http.StatusMultiStatus)
+	var results []BatchDeleteResult
+	decode(t, rec, &results)
+	want := []BatchDeleteResult{
+		{ID: melisa.ID, Status: "deleted"},
+		{ID: "missing", Status: "not_found"},
+		{ID: zeynep.ID, Status: "deleted"},
+	}
+	if !slices.Equal(results, want) {
+		t.Errorf("results = %+v, want %+v", results, want)
+	}
+	for _, id := range []string{melisa.ID, zeynep.ID} {
+		if _, err := s.users.GetByID(context.Background(), id); !errors.Is(err, store.ErrUserNotFound) {
+			t.Errorf("user %s is still there: %v", id, err)
+		}
+	}
+	if _, err := s.users.GetByID(context.Background(), ada.ID); err != nil {
+		t.Errorf("user outside the batch was deleted: %v", err)
+	}
+
+	ids := make([]string, maxBatchDeleteIDs+1)
+	for i := range ids {
+		ids[i] = `"id` + strconv.Itoa(i) + `"`
+	}
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/users/batch-delete", `{"ids":[`+strings.Join(ids, ",")+`]}`, token), http.StatusRequestEntityTooLarge)
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/users/batch-delete", `{"ids":[]}`, token), http.StatusUnprocessableEntity)
+
+	userToken := s.login(t, "ada@example.com", "abc12345")
+	expectStatus(t, s.request(http.MethodPost, "/api/v1/users/batch-delete", `{"ids":["`+ada.ID+`"]}`, userToken), http.StatusForbidden)
+}
+
+func TestUpdateKeepsAllowedEmailDomains(t *testing.T) {
+	cfg := testConfig()
+	cfg.AllowedEmailDomains = []string{"corp.com"}
+	s := newTestServer(t, cfg)
+	user := s.register(t, "Melisa", "melisa@corp.com", "abc12345")
+	token := s.login(t, "melisa@corp.com", "abc12345")
+	path := "/api/v1/users/" + user.ID
+
+	for _, req := range []struct{ method, body string }{
+		{http.MethodPatch, `{"email":"melisa@gmail.com"}`},
+		{http.MethodPut, `{"name":"Melisa","email":"melisa@gmail.com"}`},
+	} {
+		rec := s.request(req.method, path, req.body, token)
+		expectStatus(t, rec, http.StatusUnprocessableEntity)
+		var body APIError
+		decode(t, rec, &body)
+		if body.Fields["email"] != "domain gmail.com is not allowed" {
+			t.Errorf("%s: email error = %q", req.method, body.Fields["email"])
+		}
+	}
+	if stored, _ := s.users.GetByID(context.Background(), user.ID); stored.Email != "melisa@corp.com" {
+		t.Errorf("email = %q after refused updates", stored.Email)
+	}
+
+	// Allowed domains, and the email already held, go through
+	expectStatus(t, s.request(http.MethodPatch, path, `{"email":"melisa.acar@CORP.com"}`, token), http.StatusOK)
+	expectStatus(t, s.request(http.MethodPut, path, `{"name":"Melisa Acar","email":"melisa.acar@corp.com"}`, token), http.StatusOK)
+
+	// A user from before the restriction can still edit their name
+	legacy, err := s.createUser(context.Background(), model.User{Name: "Old", Email: "old@gmail.com", Password: "abc12345"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, s.request(http.MethodPut, "/api/v1/users/"+legacy.ID, `{"name":"Older","email":"old@gmail.com"}`, s.tokenFor(t, legacy)), http.StatusOK)
+}
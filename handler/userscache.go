@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+// Distinct queries kept before the cache is emptied, so varying the
+// query string can't grow it without bound
+const usersCacheMaxEntries = 1000
+
+// One page of GET /users as returned by the store
+type usersPage struct {
+	users     []model.User
+	total     int
+	fetchedAt time.Time
+}
+
+// UsersCache keeps the pages served by GET /users, keyed by the query string,
+// for a short TTL. Every write to the user store empties it.
+type UsersCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	pages      map[string]usersPage
+	generation uint64 // bumped by every write, pages fetched before one aren't kept
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// Create a cache whose pages go stale after ttl
+func NewUsersCache(ttl time.Duration) *UsersCache {
+	return &UsersCache{ttl: ttl, pages: map[string]usersPage{}}
+}
+
+// Return the page cached for key, or fetch and cache it. A failed fetch
+// isn't cached, so the next lookup asks the store again.
+func (uc *UsersCache) get(key string, fetch func() ([]model.User, int, error)) ([]model.User, int, error) {
+	uc.mu.Lock()
+	page, ok := uc.pages[key]
+	generation := uc.generation
+	uc.mu.Unlock()
+
+	if ok && time.Since(page.fetchedAt) < uc.ttl {
+		uc.hits.Add(1)
+		return page.users, page.total, nil
+	}
+	uc.misses.Add(1)
+
+	users, total, err := fetch()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// A write while fetching may have changed the page, so only keep it without one
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	if uc.generation == generation {
+		if len(uc.pages) >= usersCacheMaxEntries {
+			clear(uc.pages)
+		}
+		uc.pages[key] = usersPage{users: users, total: total, fetchedAt: time.Now()}
+	}
+	return users, total, nil
+}
+
+// Drop every cached page
+func (uc *UsersCache) Invalidate() {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	uc.generation++
+	clear(uc.pages)
+}
+
+// Lookups served from the cache
+func (uc *UsersCache) Hits() uint64 {
+	return uc.hits.Load()
+}
+
+// Lookups that had to ask the store
+func (uc *UsersCache) Misses() uint64 {
+	return uc.misses.Load()
+}
+
+// A UserStore that empties the cache after every write. SetLastLogin is
+// left out since the login time isn't part of a listed user.
+type invalidatingStore struct {
+	store.UserStore
+	cache *UsersCache
+}
+
+func (s invalidatingStore) Create(ctx context.Context, user model.User) (model.User, error) {
+	defer s.cache.Invalidate()
+	return s.UserStore.Create(ctx, user)
+}
+
+func (s invalidatingStore) Update(ctx context.Context, id string, user model.User) (model.User, error) {
+	defer s.cache.Invalidate()
+	return s.UserStore.Update(ctx, id, user)
+}
+
+func (s invalidatingStore) SetVerified(ctx context.Context, id string) error {
+	defer s.cache.Invalidate()
+	return s.UserStore.SetVerified(ctx, id)
+}
+
+func (s invalidatingStore) UpdatePassword(ctx context.Context, id, passwordHash string) error {
+	defer s.cache.Invalidate()
+	return s.UserStore.UpdatePassword(ctx, id, passwordHash)
+}
+
+func (s invalidatingStore) SetAvatar(ctx context.Context, id, path string) error {
+	defer s.cache.Invalidate()
+	return s.UserStore.SetAvatar(ctx, id, path)
+}
+
+func (s invalidatingStore) Delete(ctx context.Context, id string) error {
+	defer s.cache.Invalidate()
+	return s.UserStore.Delete(ctx, id)
+}
+
+func (s invalidatingStore) SoftDelete(ctx context.Context, id string) error {
+	defer s.cache.Invalidate()
+	return s.UserStore.SoftDelete(ctx, id)
+}
+
+func (s invalidatingStore) DeleteMany(ctx context.Context, ids []string, soft bool) ([]bool, error) {
+	defer s.cache.Invalidate()
+	return s.UserStore.DeleteMany(ctx, ids, soft)
+}
+
+func (s invalidatingStore) Restore(ctx context.Context, id string) (model.User, error) {
+	defer s.cache.Invalidate()
+	return s.UserStore.Restore(ctx, id)
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/melisacar/go-rest-api.git/model"
+	"github.com/melisacar/go-rest-api.git/store"
+)
+
+func TestUsersCacheSkipsFailedFetches(t *testing.T) {
+	uc := NewUsersCache(time.Minute)
+
+	calls := 0
+	failing := func() ([]model.User, int, error) {
+		calls++
+		return nil, 0, errStoreDown
+	}
+	if _, _, err := uc.get("page=1", failing); err == nil {
+		t.Fatal("get returned no error for a failed fetch")
+	}
+
+	working := func() ([]model.User, int, error) {
+		calls++
+		return []model.User{{ID: "1"}}, 1, nil
+	}
+	users, total, err := uc.get("page=1", working)
+	if err != nil || len(users) != 1 || total != 1 {
+		t.Fatalf("get = %v, %d, %v, want the fetched page", users, total, err)
+	}
+	if calls != 2 {
+		t.Errorf("fetch ran %d times, want 2 since the failure isn't cached", calls)
+	}
+}
+
+func TestListUsersRecoversAfterStoreFailure(t *testing.T) {
+	cfg := testConfig()
+	cfg.UsersCacheTTL = time.Minute
+	mem := store.NewMemoryUserStore()
+	fs := &failingStore{MemoryUserStore: mem}
+	s := newTestServerWithStore(t, cfg, fs, mem)
+	token := s.adminToken(t)
+
+	fs.down = true
+	expectStatus(t, s.request(http.MethodGet, "/api/v1/users", "", token), http.StatusInternalServerError)
+
+	// The failure wasn't cached as an empty page
+	fs.down = false
+	rec := s.request(http.MethodGet, "/api/v1/users", "", token)
+	expectStatus(t, rec, http.StatusOK)
+	var body struct {
+		Total int `json:"total"`
+	}
+	decode(t, rec, &body)
+	if body.Total != 1 {
+		t.Errorf("total = %d, want the admin", body.Total)
+	}
+}
+
+func TestUsersCacheInvalidatedByWrites(t *testing.T) {
+	cfg := testConfig()
+	cfg.UsersCacheTTL = time.Minute
+	cfg.MetricsEnabled = true
+	s := newTestServer(t, cfg)
+	token := s.adminToken(t)
+	s.register(t, "melisa", "melisa@example.com", "abc12345")
+
+	if page := s.listUsers(t, token, ""); page.Total != 2 {
+		t.Fatalf("total = %d, want 2", page.Total)
+	}
+
+	// Writes behind the handler's back aren't seen, so the page really is cached
+	if _, err := s.users.Create(context.Background(), model.User{Name: "hidden", Email: "hidden@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if page := s.listUsers(t, token, ""); page.Total != 2 {
+		t.Fatalf("total = %d, want the cached 2", page.Total)
+	}
+
+	// A registration empties the cache
+	s.register(t, "zeynep", "zeynep@example.com", "abc12345")
+	if page := s.listUsers(t, token, ""); page.Total != 4 {
+		t.Errorf("total = %d after a registration, want 4", page.Total)
+	}
+
+	if hits, misses := s.usersCache.Hits(), s.usersCache.Misses(); hits != 1 || misses != 2 {
+		t.Errorf("hits %d misses %d, want 1 and 2", hits, misses)
+	}
+	out := s.request(http.MethodGet, "/metrics", "", "").Body.String()
+	for _, want := range []string{"users_cache_hits_total 1", "users_cache_misses_total 2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("/metrics has no %s", want)
+		}
+	}
+}
+
+func TestUsersCacheExpires(t *testing.T) {
+	uc := NewUsersCache(time.Millisecond)
+	calls := 0
+	fetch := func() ([]model.User, int, error) {
+		calls++
+		return nil, calls, nil
+	}
+
+	uc.get("page=1", fetch)
+	uc.get("page=2", fetch)
+	time.Sleep(5 * time.Millisecond)
+	if _, total, _ := uc.get("page=1", fetch); total != 3 {
+		t.Errorf("got fetch %d, want a fresh fetch after the TTL", total)
+	}
+}
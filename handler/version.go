@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"net/http"
+	"runtime"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Version and commit the binary was built from, injected with -ldflags
+type BuildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+}
+
+// Report the build info and the Go version the binary was compiled with
+func (h *Handler) Version(c echo.Context) error {
+	return respondJSON(c, http.StatusOK, map[string]string{
+		"version": h.build.Version,
+		"commit":  h.build.Commit,
+		"go":      runtime.Version(),
+	})
+}
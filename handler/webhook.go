@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+// Header carrying "sha256=" and the hex HMAC-SHA256 of the body, keyed by WEBHOOK_SECRET
+const headerWebhookSignature = "X-Webhook-Signature"
+
+// Event sent after a user registers
+const eventUserRegistered = "user.registered"
+
+// Deliveries are tried this many times, waiting webhookBackoff and then twice
+// as long again between tries. Only network errors and 5xx responses are retried.
+var (
+	webhookAttempts = 3
+	webhookBackoff  = time.Second
+)
+
+// Body POSTed to WEBHOOK_URL
+type webhookEvent struct {
+	Event     string      `json:"event"`
+	User      webhookUser `json:"user"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// The user an event is about
+type webhookUser struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// Webhook POSTs signed events to a URL in the background, so a slow or
+// failing receiver never holds up the request that caused the event
+type Webhook struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// Create a webhook for url, or nil when no url is configured.
+// Sending on a nil Webhook does nothing.
+func NewWebhook(url, secret string) *Webhook {
+	if url == "" {
+		return nil
+	}
+	return &Webhook{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send an event about user without waiting for it to be delivered.
+// Failures are logged to logger.
+func (w *Webhook) Send(logger *slog.Logger, event string, user model.User) {
+	if w == nil {
+		return
+	}
+
+	body, err := json.Marshal(webhookEvent{
+		Event:     event,
+		User:      webhookUser{ID: user.ID, Name: user.Name, Email: user.Email},
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		logger.Error("could not encode webhook event", "event", event, "error", err)
+		return
+	}
+
+	go func() {
+		if err := w.deliver(body); err != nil {
+			logger.Warn("webhook delivery failed", "event", event, "user_id", user.ID, "error", err)
+		}
+	}()
+}
+
+// POST body until the receiver accepts it, a 4xx answer is final
+func (w *Webhook) deliver(body []byte) error {
+	signature := "sha256=" + w.sign(body)
+	backoff := webhookBackoff
+
+	var err error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		var retry bool
+		retry, err = w.post(body, signature)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// POST body once, reporting whether a failure is worth retrying
+func (w *Webhook) post(body []byte, signature string) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerWebhookSignature, signature)
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 500:
+		return true, fmt.Errorf("receiver answered %d", res.StatusCode)
+	case res.StatusCode >= 300:
+		return false, fmt.Errorf("receiver answered %d", res.StatusCode)
+	}
+	return false, nil
+}
+
+// Hex HMAC-SHA256 of body with the shared secret
+func (w *Webhook) sign(body []byte) string {
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
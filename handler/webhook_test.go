@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Make webhook retries fast for the test
+func fastWebhookRetries(t *testing.T) {
+	t.Helper()
+	backoff := webhookBackoff
+	webhookBackoff = time.Millisecond
+	t.Cleanup(func() { webhookBackoff = backoff })
+}
+
+// A delivery received by a stub webhook receiver
+type delivery struct {
+	body      []byte
+	signature string
+}
+
+func TestWebhookOnRegistration(t *testing.T) {
+	fastWebhookRetries(t)
+
+	// The receiver fails the first try, so the delivery is retried
+	var calls atomic.Int32
+	deliveries := make(chan delivery, webhookAttempts)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		deliveries <- delivery{body: body, signature: r.Header.Get(headerWebhookSignature)}
+	}))
+	defer receiver.Close()
+
+	cfg := testConfig()
+	cfg.WebhookURL = receiver.URL
+	cfg.WebhookSecret = "hook-secret"
+	s := newTestServer(t, cfg)
+	user := s.register(t, "Melisa", "melisa@example.com", "abc12345")
+
+	var got delivery
+	select {
+	case got = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook wasn't delivered")
+	}
+	if calls.Load() != 2 {
+		t.Errorf("receiver was called %d times, want 2", calls.Load())
+	}
+
+	mac := hmac.New(sha256.New, []byte("hook-secret"))
+	mac.Write(got.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); got.signature != want {
+		t.Errorf("signature = %q, want %q", got.signature, want)
+	}
+
+	var event webhookEvent
+	if err := json.Unmarshal(got.body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Event != eventUserRegistered || event.User.ID != user.ID || event.User.Email != "melisa@example.com" || event.Timestamp.IsZero() {
+		t.Errorf("event = %+v", event)
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	fastWebhookRetries(t)
+
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+
+	if err := NewWebhook(receiver.URL, "hook-secret").deliver([]byte(`{}`)); err == nil {
+		t.Error("a 400 answer was taken as delivered")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("receiver was called %d times, want 1", calls.Load())
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	fastWebhookRetries(t)
+
+	var calls atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	if err := NewWebhook(receiver.URL, "hook-secret").deliver([]byte(`{}`)); err == nil {
+		t.Error("delivery succeeded against a failing receiver")
+	}
+	if calls.Load() != int32(webhookAttempts) {
+		t.Errorf("receiver was called %d times, want %d", calls.Load(), webhookAttempts)
+	}
+}
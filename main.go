@@ -1,70 +1,153 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
 	"net/http"
-	"regexp"
-
-	// https://pkg.go.dev/regexp
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
-	// https://pkg.go.dev/github.com/labstack/echo/v4/middleware
+	// https://pkg.go.dev/github.com/labstack/echo/v4
+
+	"github.com/melisacar/go-rest-api.git/config"
+	"github.com/melisacar/go-rest-api.git/handler"
+	"github.com/melisacar/go-rest-api.git/store"
 )
 
-// Defining the User Struct
-type User struct {
-	Name     string `json:"name" validate:"required"`
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required"`
-}
+// Build info, set with -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "dev"
+	commit  = "unknown"
+)
 
 func main() {
 
-	// Echo instance
-	e := echo.New()
+	// Read the configuration, stopping before the server starts if it is invalid
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
 
-	// Middleware to log requests
-	e.Use(middleware.Logger())
+	// Structured JSON logger, also used by the standard log package
+	logger := handler.NewLogger(os.Stdout, cfg.LogLevel)
+	slog.SetDefault(logger)
 
-	// Register endpoint
-	e.POST("/register", func(c echo.Context) error {
+	// Store for registered users and the audit log of changes to them, picked by DB_DRIVER
+	var (
+		userStore store.UserStore
+		auditLog  store.AuditLog
+	)
+	switch cfg.DBDriver {
+	case "sqlite":
+		sqliteStore, err := store.NewSQLiteUserStore(cfg.DBPath)
+		if err != nil {
+			log.Fatalf("could not open database: %v", err)
+		}
+		defer sqliteStore.Close()
+		userStore = sqliteStore
+		auditLog = sqliteStore.AuditLog()
+	case "postgres":
+		postgresStore, err := store.NewPostgresUserStore(context.Background(), cfg.DatabaseURL)
+		if err != nil {
+			log.Fatalf("could not connect to database: %v", err)
+		}
+		defer postgresStore.Close()
+		userStore = postgresStore
+		auditLog = postgresStore.AuditLog()
+	default:
+		userStore = store.NewMemoryUserStore()
+		fileLog, err := store.NewFileAuditLog(cfg.AuditLogFile)
+		if err != nil {
+			log.Fatalf("could not open audit log: %v", err)
+		}
+		defer fileLog.Close()
+		auditLog = fileLog
+	}
 
-		// Initialize a User struct to bind incoming data
-		var user User // Creates a variable user of type User
+	build := handler.BuildInfo{Version: version, Commit: commit}
 
-		// Bind JSON body to the struct
-		if err := c.Bind(&user); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{
-				"error": "Invalid request",
-			})
+	// Export traces when a collector is configured, flushing them on the way out
+	if cfg.TracingEnabled() {
+		shutdownTracing, err := handler.SetupTracing(context.Background(), build)
+		if err != nil {
+			log.Fatalf("could not set up tracing: %v", err)
 		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdownTracing(ctx); err != nil {
+				slog.Warn("could not flush traces", "error", err)
+			}
+		}()
+	}
 
-		// Validate email format
-		if !isValidEmail(user.Email) {
-			return c.JSON(http.StatusUnprocessableEntity, map[string]string{
-				"error": "Invalid email format",
-			})
+	h := handler.New(userStore, auditLog, cfg, build)
 
+	// Make sure there is an admin to log in with
+	if cfg.AdminEmail != "" {
+		if err := h.SeedAdmin(context.Background(), cfg.AdminEmail, cfg.AdminPassword); err != nil {
+			log.Fatalf("could not seed admin: %v", err)
 		}
+	}
 
-		// Return success response
-		return c.JSON(http.StatusOK, map[string]interface{}{
-			"message": "User registered successfully",
-			"user": map[string]string{
-				"name":  user.Name,
-				"email": user.Email,
-			},
-		})
-	})
-
-	// Start the server and listen on port 1212
-	e.Logger.Fatal(e.Start(":1212"))
-}
+	// Echo instance, its startup banner is replaced by a JSON log line
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+
+	// Ping the store in the background until shutdown
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	go h.WatchStore(watchCtx)
+
+	// Middleware, API routes and docs
+	h.Configure(e, logger)
+	handler.RegisterRoutes(e, h)
+	registerDocs(e)
+
+	// Start the server in the background and listen on the configured port
+	go func() {
+		slog.Info("server started", "addr", cfg.Addr(), "tls", cfg.TLSEnabled())
+
+		// HTTPS also enables HTTP/2
+		var err error
+		if cfg.TLSEnabled() {
+			err = e.StartTLS(cfg.Addr(), cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			err = e.Start(cfg.Addr())
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	// SIGUSR1 switches maintenance mode on and off without a restart
+	if cfg.MaintenanceMode {
+		slog.Warn("maintenance mode enabled, writes are refused")
+	}
+	toggle := make(chan os.Signal, 1)
+	signal.Notify(toggle, syscall.SIGUSR1)
+	go func() {
+		for range toggle {
+			h.Maintenance().Toggle()
+		}
+	}()
+
+	// Wait for Ctrl-C or SIGTERM
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
 
-// Email validation function
-func isValidEmail(email string) bool {
-	// Basic email regex
-	regex := `^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`
-	re := regexp.MustCompile(regex)
-	return re.MatchString(email)
+	// Let in-flight requests finish, for at most 10 seconds
+	slog.Info("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		e.Logger.Fatal(err)
+	}
 }
@@ -0,0 +1,83 @@
+// Package model holds the user types shared by the store and the handlers.
+package model
+
+import (
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Defining the User Struct
+type User struct {
+	ID       string `json:"id"`
+	Name     string `json:"name" validate:"required,max=100"`
+	Email    string `json:"email" validate:"required,max=254,email"`
+	Password string `json:"password" validate:"required,password" trim:"-"`
+
+	// Bcrypt hash of Password, never serialized
+	PasswordHash string `json:"-"`
+
+	// Set once the user opens the link from their verification token
+	Verified bool `json:"-"`
+
+	// RoleUser or RoleAdmin, it can't be set through the request body
+	Role string `json:"-"`
+
+	// Set by the store on Create, UpdatedAt is bumped on every change
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+
+	// Set when the user is soft-deleted, such users are hidden until restored
+	DeletedAt *time.Time `json:"-"`
+
+	// Time of the last password login, nil until the first one
+	LastLoginAt *time.Time `json:"-"`
+
+	// File holding the uploaded profile image, empty without one
+	AvatarPath string `json:"-"`
+}
+
+// User roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Public view of a user, it has no password fields so they can never be serialized
+type UserResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Only set on soft-deleted users, which admins can list with ?include_deleted=true
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Convert a User to its public view
+func NewUserResponse(user User) UserResponse {
+	return UserResponse{
+		ID:        user.ID,
+		Name:      user.Name,
+		Email:     user.Email,
+		Role:      user.Role,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		DeletedAt: user.DeletedAt,
+	}
+}
+
+// Email validation function
+func IsValidEmail(email string) bool {
+	// Accept a bare address only, not "Name <address>" or one padded with spaces
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || strings.HasSuffix(email, ">") || strings.TrimSpace(email) != email {
+		return false
+	}
+
+	// Require a dotted domain such as example.com
+	domain := email[strings.LastIndex(email, "@")+1:]
+	return strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
@@ -0,0 +1,33 @@
+package model
+
+import "testing"
+
+func TestIsValidEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		valid bool
+	}{
+		{"melisa@example.com", true},
+		{"melisa+test@example.com", true},
+		{"melisa.acar@mail.example.co.uk", true},
+		{`"melisa acar"@example.com`, true},
+		{"melisa@bücher.de", true},
+		{"melisa@localhost", false},
+		{"melisa@example.com.", false},
+		{"melisa@.example.com", false},
+		{"melisa@", false},
+		{"@example.com", false},
+		{"melisa", false},
+		{"melisa@@example.com", false},
+		{"Melisa <melisa@example.com>", false},
+		{"<melisa@example.com>", false},
+		{" melisa@example.com", false},
+		{"melisa@example.com ", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsValidEmail(tt.email); got != tt.valid {
+			t.Errorf("IsValidEmail(%q) = %v, want %v", tt.email, got, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audited actions
+const (
+	AuditUserCreate         = "user.create"
+	AuditUserUpdate         = "user.update"
+	AuditUserDelete         = "user.delete"
+	AuditUserRestore        = "user.restore"
+	AuditUserVerify         = "user.verify"
+	AuditUserPasswordChange = "user.password_change"
+	AuditUserPasswordReset  = "user.password_reset"
+	AuditUserAvatar         = "user.avatar"
+)
+
+// One write to a user. It only names who did what to whom and when,
+// so no password or hash can end up in the log.
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	Actor     string    `json:"actor"` // user id from the token, or "anonymous" or "system"
+	Action    string    `json:"action"`
+	TargetID  string    `json:"target_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditLog is an append-only record of writes. Entries are never changed or removed.
+type AuditLog interface {
+	Append(ctx context.Context, entry AuditEntry) error
+	List(ctx context.Context, action, actor string, offset, limit int) ([]AuditEntry, int, error)
+	ForUser(ctx context.Context, userID string) ([]AuditEntry, error)
+}
+
+// FileAuditLog appends entries to a file as JSON lines and keeps them in
+// memory for listing. It is used with the in-memory user store.
+type FileAuditLog struct {
+	mu      sync.RWMutex
+	file    *os.File
+	entries []AuditEntry
+}
+
+// Open the log at path, creating it if needed and reading back earlier entries
+func NewFileAuditLog(path string) (*FileAuditLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	log := &FileAuditLog{file: file}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			file.Close()
+			return nil, err
+		}
+		log.entries = append(log.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return log, nil
+}
+
+// Close the file
+func (l *FileAuditLog) Close() error {
+	return l.file.Close()
+}
+
+// Append numbers and timestamps the entry, then writes it out
+func (l *FileAuditLog) Append(ctx context.Context, entry AuditEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.ID = int64(len(l.entries)) + 1
+	entry.CreatedAt = time.Now().UTC()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+// List pages through the entries newest first, only those with the action
+// and actor when they are given, along with the number of matching entries
+func (l *FileAuditLog) List(ctx context.Context, action, actor string, offset, limit int) ([]AuditEntry, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	var matches []AuditEntry
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		entry := l.entries[i]
+		if (action == "" || entry.Action == action) && (actor == "" || entry.Actor == actor) {
+			matches = append(matches, entry)
+		}
+	}
+
+	total := len(matches)
+	if offset >= total {
+		return []AuditEntry{}, total, nil
+	}
+	return matches[offset:min(offset+limit, total)], total, nil
+}
+
+// ForUser returns every entry made by or about the user, oldest first
+func (l *FileAuditLog) ForUser(ctx context.Context, userID string) ([]AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entries := []AuditEntry{}
+	for _, entry := range l.entries {
+		if entry.Actor == userID || entry.TargetID == userID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Table created on startup next to users. Only INSERT and SELECT run against it.
+const createAuditLogTable = `
+CREATE TABLE IF NOT EXISTS audit_log (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	actor      TEXT NOT NULL,
+	action     TEXT NOT NULL,
+	target_id  TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+)`
+
+// SQLiteAuditLog keeps entries in the audit_log table of the users database
+type SQLiteAuditLog struct {
+	db *sql.DB
+}
+
+// The audit log stored alongside the users
+func (s *SQLiteUserStore) AuditLog() *SQLiteAuditLog {
+	return &SQLiteAuditLog{db: s.db}
+}
+
+// Append timestamps the entry and inserts it
+func (l *SQLiteAuditLog) Append(ctx context.Context, entry AuditEntry) error {
+	_, err := l.db.ExecContext(ctx,
+		`INSERT INTO audit_log (actor, action, target_id, created_at) VALUES (?, ?, ?, ?)`,
+		entry.Actor, entry.Action, entry.TargetID, time.Now().UTC(),
+	)
+	return err
+}
+
+// List pages through the entries newest first, only those with the action
+// and actor when they are given, along with the number of matching entries
+func (l *SQLiteAuditLog) List(ctx context.Context, action, actor string, offset, limit int) ([]AuditEntry, int, error) {
+	where := ` WHERE (? = '' OR action = ?) AND (? = '' OR actor = ?)`
+	args := []interface{}{action, action, actor, actor}
+
+	var total int
+	if err := l.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := l.db.QueryContext(ctx,
+		`SELECT id, actor, action, target_id, created_at FROM audit_log`+where+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.TargetID, &entry.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// ForUser returns every entry made by or about the user, oldest first
+func (l *SQLiteAuditLog) ForUser(ctx context.Context, userID string) ([]AuditEntry, error) {
+	rows, err := l.db.QueryContext(ctx,
+		`SELECT id, actor, action, target_id, created_at FROM audit_log WHERE actor = ?1 OR target_id = ?1 ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.TargetID, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// Check entries are numbered, listed newest first with the filters, and found per user
+func testAuditLog(t *testing.T, log AuditLog) {
+	t.Helper()
+	ctx := context.Background()
+	for _, entry := range []AuditEntry{
+		{Actor: "anonymous", Action: AuditUserCreate, TargetID: "u1"},
+		{Actor: "u1", Action: AuditUserUpdate, TargetID: "u1"},
+		{Actor: "admin", Action: AuditUserDelete, TargetID: "u2"},
+	} {
+		if err := log.Append(ctx, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, total, err := log.List(ctx, "", "", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(entries) != 3 {
+		t.Fatalf("got %d of %d entries, want 3", len(entries), total)
+	}
+	if entries[0].Action != AuditUserDelete || entries[2].Action != AuditUserCreate {
+		t.Errorf("entries aren't newest first: %+v", entries)
+	}
+	for _, entry := range entries {
+		if entry.ID == 0 || entry.CreatedAt.IsZero() {
+			t.Errorf("entry wasn't numbered and timestamped: %+v", entry)
+		}
+	}
+
+	if entries, total, _ := log.List(ctx, AuditUserUpdate, "", 0, 10); total != 1 || entries[0].Actor != "u1" {
+		t.Errorf("filtered by action = %+v", entries)
+	}
+	if entries, total, _ := log.List(ctx, "", "admin", 0, 10); total != 1 || entries[0].TargetID != "u2" {
+		t.Errorf("filtered by actor = %+v", entries)
+	}
+	if entries, total, _ := log.List(ctx, "", "", 2, 10); total != 3 || len(entries) != 1 {
+		t.Errorf("offset page = %+v of %d", entries, total)
+	}
+
+	// A cancelled request is an error, not an empty page
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := log.List(cancelled, "", "", 0, 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("List with a cancelled context = %v, want context.Canceled", err)
+	}
+
+	mine, err := log.ForUser(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mine) != 2 || mine[0].Action != AuditUserCreate || mine[1].Action != AuditUserUpdate {
+		t.Errorf("entries for u1 = %+v", mine)
+	}
+}
+
+func TestFileAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := NewFileAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAuditLog(t, log)
+	log.Close()
+
+	// Entries survive a restart and numbering carries on
+	log, err = NewFileAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	if err := log.Append(context.Background(), AuditEntry{Actor: "system", Action: AuditUserVerify, TargetID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	entries, total, err := log.List(context.Background(), "", "", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4 || entries[0].ID != 4 {
+		t.Errorf("after reopening got %d entries, newest %+v", total, entries[0])
+	}
+}
@@ -0,0 +1,432 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	// https://pkg.go.dev/github.com/jackc/pgx/v5
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+// Tables created on startup if they don't exist yet, the Postgres
+// counterparts of createUsersTable and createAuditLogTable
+const createPostgresTables = `
+CREATE TABLE IF NOT EXISTS users (
+	id            TEXT PRIMARY KEY,
+	name          TEXT NOT NULL CHECK (char_length(name) <= 100),
+	email         TEXT NOT NULL UNIQUE CHECK (char_length(email) <= 254),
+	password_hash TEXT NOT NULL,
+	created_at    TIMESTAMPTZ NOT NULL,
+	updated_at    TIMESTAMPTZ NOT NULL,
+	verified      BOOLEAN NOT NULL DEFAULT false,
+	role          TEXT NOT NULL DEFAULT 'user',
+	deleted_at    TIMESTAMPTZ,
+	last_login_at TIMESTAMPTZ,
+	avatar_path   TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+	actor      TEXT NOT NULL,
+	action     TEXT NOT NULL,
+	target_id  TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+)`
+
+// SQLSTATE of a UNIQUE constraint violation
+const pgUniqueViolation = "23505"
+
+// PostgresUserStore keeps users in a PostgreSQL database, over a pool of connections
+type PostgresUserStore struct {
+	pool *pgxpool.Pool
+}
+
+// Connect to the database at url and create the tables. Pool settings such as
+// pool_max_conns can be given as url parameters.
+func NewPostgresUserStore(ctx context.Context, url string) (*PostgresUserStore, error) {
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	// pgxpool connects lazily, so make sure the database is there before migrating
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	if _, err := pool.Exec(ctx, createPostgresTables); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return &PostgresUserStore{pool: pool}, nil
+}
+
+// Close every connection of the pool
+func (s *PostgresUserStore) Close() {
+	s.pool.Close()
+}
+
+// Postgres keeps microseconds, so timestamps are cut to them up front and
+// the user returned by a write matches the one read back later
+func pgNow() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// Create saves a new user under a fresh UUID and returns the stored user
+func (s *PostgresUserStore) Create(ctx context.Context, user model.User) (model.User, error) {
+	user.ID = uuid.NewString()
+	user.Email = NormalizeEmail(user.Email)
+	if user.Role == "" {
+		user.Role = model.RoleUser
+	}
+	user.CreatedAt = pgNow()
+	user.UpdatedAt = user.CreatedAt
+
+	_, err := s.pool.Exec(ctx,
+		`INSERT INTO users (id, name, email, password_hash, created_at, updated_at, verified, role) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		user.ID, user.Name, user.Email, user.PasswordHash, user.CreatedAt, user.UpdatedAt, user.Verified, user.Role,
+	)
+	if err != nil {
+		if isPgUniqueViolation(err) {
+			return model.User{}, ErrEmailExists
+		}
+		return model.User{}, err
+	}
+
+	return user, nil
+}
+
+// GetByEmail finds a user by email
+func (s *PostgresUserStore) GetByEmail(ctx context.Context, email string) (model.User, error) {
+	row := s.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE email = $1 AND deleted_at IS NULL`, NormalizeEmail(email))
+	return scanPgUser(row)
+}
+
+// GetByID finds a user by id
+func (s *PostgresUserStore) GetByID(ctx context.Context, id string) (model.User, error) {
+	row := s.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1 AND deleted_at IS NULL`, id)
+	return scanPgUser(row)
+}
+
+// EmailTaken reports whether Create would refuse the email, which soft-deleted users keep
+func (s *PostgresUserStore) EmailTaken(ctx context.Context, email string) (bool, error) {
+	var taken bool
+	err := s.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`, NormalizeEmail(email)).Scan(&taken)
+	return taken, err
+}
+
+// List returns every user that isn't deleted, sorted by name
+func (s *PostgresUserStore) List(ctx context.Context) ([]model.User, error) {
+	return s.queryUsers(ctx, `SELECT `+userColumns+` FROM users WHERE deleted_at IS NULL ORDER BY name`)
+}
+
+// ListPaged returns up to limit users in the given order, starting at offset,
+// along with the total number of users
+func (s *PostgresUserStore) ListPaged(ctx context.Context, sort UserSort, includeDeleted bool, offset, limit int) ([]model.User, int, error) {
+	where := notDeleted(includeDeleted, " WHERE ")
+
+	var total int
+	if err := s.pool.QueryRow(ctx, `SELECT COUNT(*) FROM users`+where).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	users, err := s.queryUsers(ctx, `SELECT `+userColumns+` FROM users`+where+` ORDER BY `+orderBy(sort)+` LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// ListAfter returns up to limit users created after the cursor, oldest first
+func (s *PostgresUserStore) ListAfter(ctx context.Context, after UserCursor, includeDeleted bool, limit int) ([]model.User, error) {
+	return s.queryUsers(ctx,
+		`SELECT `+userColumns+` FROM users WHERE (created_at, id) > ($1, $2)`+notDeleted(includeDeleted, " AND ")+
+			` ORDER BY created_at, id LIMIT $3`,
+		after.CreatedAt.UTC(), after.ID, limit,
+	)
+}
+
+// Search pages through the users whose name or email contains query, ignoring case,
+// and whose role is role. An empty query or role matches every user.
+func (s *PostgresUserStore) Search(ctx context.Context, query, role string, includeDeleted bool, offset, limit int) ([]model.User, int, error) {
+	query = strings.ToLower(query)
+	where := ` WHERE ($1::text = '' OR strpos(lower(name), $1) > 0 OR strpos(email, $1) > 0) AND ($2::text = '' OR role = $2)` + notDeleted(includeDeleted, " AND ")
+
+	var total int
+	if err := s.pool.QueryRow(ctx, `SELECT COUNT(*) FROM users`+where, query, role).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	users, err := s.queryUsers(ctx, `SELECT `+userColumns+` FROM users`+where+` ORDER BY name LIMIT $3 OFFSET $4`, query, role, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// Update replaces the name and email of an existing user and returns the stored user,
+// the password is left unchanged
+func (s *PostgresUserStore) Update(ctx context.Context, id string, user model.User) (model.User, error) {
+	row := s.pool.QueryRow(ctx,
+		`UPDATE users SET name = $1, email = $2, updated_at = $3 WHERE id = $4 AND deleted_at IS NULL RETURNING `+userColumns,
+		user.Name, NormalizeEmail(user.Email), pgNow(), id,
+	)
+	updated, err := scanUserColumns(row)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return model.User{}, ErrUserNotFound
+		case isPgUniqueViolation(err):
+			return model.User{}, ErrEmailExists
+		}
+		return model.User{}, err
+	}
+	return updated, nil
+}
+
+// SetVerified marks a user's email as verified
+func (s *PostgresUserStore) SetVerified(ctx context.Context, id string) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE users SET verified = true, updated_at = $1 WHERE id = $2 AND deleted_at IS NULL`, pgNow(), id)
+	return requirePgRowAffected(tag, err)
+}
+
+// UpdatePassword replaces a user's password hash
+func (s *PostgresUserStore) UpdatePassword(ctx context.Context, id, passwordHash string) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE users SET password_hash = $1, updated_at = $2 WHERE id = $3 AND deleted_at IS NULL`, passwordHash, pgNow(), id)
+	return requirePgRowAffected(tag, err)
+}
+
+// SetLastLogin records when a user last logged in, it isn't counted as a change to updated_at
+func (s *PostgresUserStore) SetLastLogin(ctx context.Context, id string, at time.Time) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE users SET last_login_at = $1 WHERE id = $2 AND deleted_at IS NULL`, at.UTC(), id)
+	return requirePgRowAffected(tag, err)
+}
+
+// SetAvatar records the file holding a user's profile image
+func (s *PostgresUserStore) SetAvatar(ctx context.Context, id, path string) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE users SET avatar_path = $1, updated_at = $2 WHERE id = $3 AND deleted_at IS NULL`, path, pgNow(), id)
+	return requirePgRowAffected(tag, err)
+}
+
+// Delete removes a user
+func (s *PostgresUserStore) Delete(ctx context.Context, id string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM users WHERE id = $1`, id)
+	return requirePgRowAffected(tag, err)
+}
+
+// DeleteMany deletes, or with soft only marks deleted, every user in ids in one
+// transaction, so an error leaves them all in place. It reports for each id
+// whether that user existed.
+func (s *PostgresUserStore) DeleteMany(ctx context.Context, ids []string, soft bool) ([]bool, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	now := pgNow()
+	deleted := make([]bool, len(ids))
+	for i, id := range ids {
+		var tag pgconn.CommandTag
+		if soft {
+			tag, err = tx.Exec(ctx, `UPDATE users SET deleted_at = $1, updated_at = $1 WHERE id = $2 AND deleted_at IS NULL`, now, id)
+		} else {
+			tag, err = tx.Exec(ctx, `DELETE FROM users WHERE id = $1`, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+		deleted[i] = tag.RowsAffected() > 0
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// SoftDelete marks a user deleted, hiding them until Restore
+func (s *PostgresUserStore) SoftDelete(ctx context.Context, id string) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE users SET deleted_at = $1, updated_at = $1 WHERE id = $2 AND deleted_at IS NULL`, pgNow(), id)
+	return requirePgRowAffected(tag, err)
+}
+
+// Restore undoes a soft delete and returns the user, restoring one that isn't deleted is a no-op
+func (s *PostgresUserStore) Restore(ctx context.Context, id string) (model.User, error) {
+	row := s.pool.QueryRow(ctx,
+		`UPDATE users SET deleted_at = NULL, updated_at = CASE WHEN deleted_at IS NULL THEN updated_at ELSE $1 END WHERE id = $2 RETURNING `+userColumns,
+		pgNow(), id,
+	)
+	user, err := scanUserColumns(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.User{}, ErrUserNotFound
+		}
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// Stats counts the users that aren't deleted by verification and role in a single grouped query
+func (s *PostgresUserStore) Stats(ctx context.Context) (StatsResult, error) {
+	rows, err := s.pool.Query(ctx, `SELECT role, verified, COUNT(*) FROM users WHERE deleted_at IS NULL GROUP BY role, verified`)
+	if err != nil {
+		return StatsResult{}, err
+	}
+	defer rows.Close()
+
+	stats := StatsResult{ByRole: map[string]int{}}
+	for rows.Next() {
+		var (
+			role     string
+			verified bool
+			count    int
+		)
+		if err := rows.Scan(&role, &verified, &count); err != nil {
+			return StatsResult{}, err
+		}
+		stats.Total += count
+		if verified {
+			stats.Verified += count
+		} else {
+			stats.Unverified += count
+		}
+		stats.ByRole[role] += count
+	}
+	return stats, rows.Err()
+}
+
+// Ping checks the database answers queries
+func (s *PostgresUserStore) Ping(ctx context.Context) error {
+	return s.pool.Ping(ctx)
+}
+
+// Run a query returning user rows
+func (s *PostgresUserStore) queryUsers(ctx context.Context, query string, args ...interface{}) ([]model.User, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return []model.User{}, err
+	}
+	defer rows.Close()
+
+	users := []model.User{}
+	for rows.Next() {
+		user, err := scanUserColumns(rows)
+		if err != nil {
+			return []model.User{}, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// Scan a single user row, ErrUserNotFound when there is none
+func scanPgUser(row pgx.Row) (model.User, error) {
+	user, err := scanUserColumns(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// Return ErrUserNotFound when a statement didn't touch any row
+func requirePgRowAffected(tag pgconn.CommandTag, err error) error {
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Report whether err is a UNIQUE constraint violation, i.e. a taken email
+func isPgUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+// PostgresAuditLog keeps entries in the audit_log table of the users database
+type PostgresAuditLog struct {
+	pool *pgxpool.Pool
+}
+
+// The audit log stored alongside the users
+func (s *PostgresUserStore) AuditLog() *PostgresAuditLog {
+	return &PostgresAuditLog{pool: s.pool}
+}
+
+// Append timestamps the entry and inserts it
+func (l *PostgresAuditLog) Append(ctx context.Context, entry AuditEntry) error {
+	_, err := l.pool.Exec(ctx,
+		`INSERT INTO audit_log (actor, action, target_id, created_at) VALUES ($1, $2, $3, $4)`,
+		entry.Actor, entry.Action, entry.TargetID, pgNow(),
+	)
+	return err
+}
+
+// List pages through the entries newest first, only those with the action
+// and actor when they are given, along with the number of matching entries
+func (l *PostgresAuditLog) List(ctx context.Context, action, actor string, offset, limit int) ([]AuditEntry, int, error) {
+	where := ` WHERE ($1::text = '' OR action = $1) AND ($2::text = '' OR actor = $2)`
+
+	var total int
+	if err := l.pool.QueryRow(ctx, `SELECT COUNT(*) FROM audit_log`+where, action, actor).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := l.pool.Query(ctx,
+		`SELECT id, actor, action, target_id, created_at FROM audit_log`+where+` ORDER BY id DESC LIMIT $3 OFFSET $4`,
+		action, actor, limit, offset,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.TargetID, &entry.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// ForUser returns every entry made by or about the user, oldest first
+func (l *PostgresAuditLog) ForUser(ctx context.Context, userID string) ([]AuditEntry, error) {
+	rows, err := l.pool.Query(ctx,
+		`SELECT id, actor, action, target_id, created_at FROM audit_log WHERE actor = $1 OR target_id = $1 ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.TargetID, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
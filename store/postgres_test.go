@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+// Connect to the database at TEST_DATABASE_URL with empty tables, skipping
+// the test when it isn't set. The tables are emptied, so don't point it at
+// a database whose data matters.
+func newTestPostgresStore(t *testing.T) *PostgresUserStore {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL isn't set")
+	}
+
+	ctx := context.Background()
+	s, err := NewPostgresUserStore(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	if _, err := s.pool.Exec(ctx, `TRUNCATE users, audit_log RESTART IDENTITY`); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPostgresCreateAndGet(t *testing.T) {
+	s := newTestPostgresStore(t)
+	ctx := context.Background()
+
+	created, err := s.Create(ctx, model.User{Name: "Melisa", Email: "melisa@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.CreatedAt.IsZero() {
+		t.Errorf("Create didn't set the id and creation time: %+v", created)
+	}
+
+	byID, err := s.GetByID(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byEmail, err := s.GetByEmail(ctx, "melisa@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, got := range []model.User{byID, byEmail} {
+		if got.ID != created.ID || got.Name != "Melisa" || got.PasswordHash != "hash" || !got.CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("got %+v, want %+v", got, created)
+		}
+	}
+	if _, err := s.GetByID(ctx, "missing"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetByID of a missing user = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestPostgresDuplicateEmail(t *testing.T) {
+	s := newTestPostgresStore(t)
+	ctx := context.Background()
+	if _, err := s.Create(ctx, model.User{Name: "Melisa", Email: "melisa@example.com", PasswordHash: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.Create(ctx, model.User{Name: "Other", Email: "melisa@example.com", PasswordHash: "hash"})
+	if !errors.Is(err, ErrEmailExists) {
+		t.Errorf("Create with a taken email = %v, want ErrEmailExists", err)
+	}
+}
+
+func TestPostgresPing(t *testing.T) {
+	s := newTestPostgresStore(t)
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping = %v", err)
+	}
+}
+
+func TestPostgresTimestamps(t *testing.T) {
+	testTimestamps(t, newTestPostgresStore(t))
+}
+
+func TestPostgresLastLogin(t *testing.T) {
+	testLastLogin(t, newTestPostgresStore(t))
+}
+
+func TestPostgresListAfter(t *testing.T) {
+	testListAfter(t, newTestPostgresStore(t))
+}
+
+func TestPostgresDeleteMany(t *testing.T) {
+	testDeleteMany(t, newTestPostgresStore(t))
+}
+
+func TestPostgresAuditLog(t *testing.T) {
+	testAuditLog(t, newTestPostgresStore(t).AuditLog())
+}
+
+func TestPostgresListPagedSort(t *testing.T) {
+	testListPagedSort(t, newTestPostgresStore(t))
+}
+
+func TestPostgresStats(t *testing.T) {
+	testStats(t, newTestPostgresStore(t))
+}
@@ -0,0 +1,475 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"modernc.org/sqlite"
+	// https://pkg.go.dev/modernc.org/sqlite
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+// Table created on startup if it doesn't exist yet. The length checks match the
+// validate tags on model.User, tables created before them don't have them.
+const createUsersTable = `
+CREATE TABLE IF NOT EXISTS users (
+	id            TEXT PRIMARY KEY,
+	name          TEXT NOT NULL CHECK (length(name) <= 100),
+	email         TEXT NOT NULL UNIQUE CHECK (length(email) <= 254),
+	password_hash TEXT NOT NULL,
+	created_at    TIMESTAMP NOT NULL,
+	updated_at    TIMESTAMP NOT NULL,
+	verified      INTEGER NOT NULL DEFAULT 0,
+	role          TEXT NOT NULL DEFAULT 'user',
+	deleted_at    TIMESTAMP,
+	last_login_at TIMESTAMP,
+	avatar_path   TEXT NOT NULL DEFAULT ''
+)`
+
+// Columns added after the first release, so older databases get them on startup.
+// The optional backfill statement fills the new column in for existing rows.
+var addedUserColumns = []struct{ name, definition, backfill string }{
+	{"verified", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"role", "TEXT NOT NULL DEFAULT 'user'", ""},
+	{"updated_at", "TIMESTAMP", "UPDATE users SET updated_at = created_at"},
+	{"deleted_at", "TIMESTAMP", ""},
+	{"last_login_at", "TIMESTAMP", ""},
+	{"avatar_path", "TEXT NOT NULL DEFAULT ''", ""},
+}
+
+// Columns read into a User, in scan order
+const userColumns = `id, name, email, password_hash, verified, role, created_at, updated_at, deleted_at, last_login_at, avatar_path`
+
+// SQLiteUserStore keeps users in a SQLite database file
+type SQLiteUserStore struct {
+	db *sql.DB
+}
+
+// Open the database at path and create the users table
+func NewSQLiteUserStore(path string) (*SQLiteUserStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, so share one connection
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteUserStore{db: db}, nil
+}
+
+// Create the users and audit_log tables and add any columns users is missing
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(createUsersTable); err != nil {
+		return err
+	}
+	if _, err := db.Exec(createAuditLogTable); err != nil {
+		return err
+	}
+
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('users')`)
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, col := range addedUserColumns {
+		if existing[col.name] {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE users ADD COLUMN ` + col.name + ` ` + col.definition); err != nil {
+			return err
+		}
+		if col.backfill == "" {
+			continue
+		}
+		if _, err := db.Exec(col.backfill); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close the database
+func (s *SQLiteUserStore) Close() error {
+	return s.db.Close()
+}
+
+// Create saves a new user under a fresh UUID and returns the stored user
+func (s *SQLiteUserStore) Create(ctx context.Context, user model.User) (model.User, error) {
+	user.ID = uuid.NewString()
+	user.Email = NormalizeEmail(user.Email)
+	if user.Role == "" {
+		user.Role = model.RoleUser
+	}
+	user.CreatedAt = time.Now().UTC()
+	user.UpdatedAt = user.CreatedAt
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO users (id, name, email, password_hash, created_at, updated_at, verified, role) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.ID, user.Name, user.Email, user.PasswordHash, user.CreatedAt, user.UpdatedAt, user.Verified, user.Role,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return model.User{}, ErrEmailExists
+		}
+		return model.User{}, err
+	}
+
+	return user, nil
+}
+
+// GetByEmail finds a user by email
+func (s *SQLiteUserStore) GetByEmail(ctx context.Context, email string) (model.User, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email = ? AND deleted_at IS NULL`, NormalizeEmail(email))
+	return scanUser(row)
+}
+
+// GetByID finds a user by id
+func (s *SQLiteUserStore) GetByID(ctx context.Context, id string) (model.User, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = ? AND deleted_at IS NULL`, id)
+	return scanUser(row)
+}
+
+// EmailTaken reports whether Create would refuse the email, which soft-deleted users keep
+func (s *SQLiteUserStore) EmailTaken(ctx context.Context, email string) (bool, error) {
+	var taken bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE email = ?)`, NormalizeEmail(email)).Scan(&taken)
+	return taken, err
+}
+
+// List returns every user that isn't deleted, sorted by name
+func (s *SQLiteUserStore) List(ctx context.Context) ([]model.User, error) {
+	return s.queryUsers(ctx, `SELECT `+userColumns+` FROM users WHERE deleted_at IS NULL ORDER BY name`)
+}
+
+// ListPaged returns up to limit users in the given order, starting at offset,
+// along with the total number of users
+func (s *SQLiteUserStore) ListPaged(ctx context.Context, sort UserSort, includeDeleted bool, offset, limit int) ([]model.User, int, error) {
+	where := notDeleted(includeDeleted, " WHERE ")
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`+where).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	users, err := s.queryUsers(ctx, `SELECT `+userColumns+` FROM users`+where+` ORDER BY `+orderBy(sort)+` LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// ListAfter returns up to limit users created after the cursor, oldest first
+func (s *SQLiteUserStore) ListAfter(ctx context.Context, after UserCursor, includeDeleted bool, limit int) ([]model.User, error) {
+	return s.queryUsers(ctx,
+		`SELECT `+userColumns+` FROM users WHERE (created_at > ? OR (created_at = ? AND id > ?))`+notDeleted(includeDeleted, " AND ")+
+			` ORDER BY created_at, id LIMIT ?`,
+		after.CreatedAt.UTC(), after.CreatedAt.UTC(), after.ID, limit,
+	)
+}
+
+// Search pages through the users whose name or email contains query, ignoring case,
+// and whose role is role. An empty query or role matches every user.
+func (s *SQLiteUserStore) Search(ctx context.Context, query, role string, includeDeleted bool, offset, limit int) ([]model.User, int, error) {
+	query = strings.ToLower(query)
+	where := ` WHERE (? = '' OR instr(lower(name), ?) > 0 OR instr(email, ?) > 0) AND (? = '' OR role = ?)` + notDeleted(includeDeleted, " AND ")
+	args := []interface{}{query, query, query, role, role}
+
+	var total int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	users, err := s.queryUsers(ctx, `SELECT `+userColumns+` FROM users`+where+` ORDER BY name LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// Update replaces the name and email of an existing user and returns the stored user,
+// the password is left unchanged
+func (s *SQLiteUserStore) Update(ctx context.Context, id string, user model.User) (model.User, error) {
+	row := s.db.QueryRowContext(ctx,
+		`UPDATE users SET name = ?, email = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL RETURNING `+userColumns,
+		user.Name, NormalizeEmail(user.Email), time.Now().UTC(), id,
+	)
+	updated, err := scanUserColumns(row)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return model.User{}, ErrUserNotFound
+		case isUniqueViolation(err):
+			return model.User{}, ErrEmailExists
+		}
+		return model.User{}, err
+	}
+	return updated, nil
+}
+
+// SetVerified marks a user's email as verified
+func (s *SQLiteUserStore) SetVerified(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET verified = 1, updated_at = ? WHERE id = ? AND deleted_at IS NULL`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	return requireRowAffected(res)
+}
+
+// UpdatePassword replaces a user's password hash
+func (s *SQLiteUserStore) UpdatePassword(ctx context.Context, id, passwordHash string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET password_hash = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL`, passwordHash, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	return requireRowAffected(res)
+}
+
+// SetLastLogin records when a user last logged in, it isn't counted as a change to updated_at
+func (s *SQLiteUserStore) SetLastLogin(ctx context.Context, id string, at time.Time) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET last_login_at = ? WHERE id = ? AND deleted_at IS NULL`, at.UTC(), id)
+	if err != nil {
+		return err
+	}
+	return requireRowAffected(res)
+}
+
+// SetAvatar records the file holding a user's profile image
+func (s *SQLiteUserStore) SetAvatar(ctx context.Context, id, path string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET avatar_path = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL`, path, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+	return requireRowAffected(res)
+}
+
+// Delete removes a user
+func (s *SQLiteUserStore) Delete(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	return requireRowAffected(res)
+}
+
+// DeleteMany deletes, or with soft only marks deleted, every user in ids in one
+// transaction, so an error leaves them all in place. It reports for each id
+// whether that user existed.
+func (s *SQLiteUserStore) DeleteMany(ctx context.Context, ids []string, soft bool) ([]bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM users WHERE id = ?`
+	if soft {
+		query = `UPDATE users SET deleted_at = ?1, updated_at = ?1 WHERE id = ?2 AND deleted_at IS NULL`
+	}
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	deleted := make([]bool, len(ids))
+	for i, id := range ids {
+		args := []interface{}{id}
+		if soft {
+			args = []interface{}{now, id}
+		}
+		res, err := stmt.ExecContext(ctx, args...)
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		deleted[i] = n > 0
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// SoftDelete marks a user deleted, hiding them until Restore
+func (s *SQLiteUserStore) SoftDelete(ctx context.Context, id string) error {
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET deleted_at = ?, updated_at = ? WHERE id = ? AND deleted_at IS NULL`, now, now, id)
+	if err != nil {
+		return err
+	}
+	return requireRowAffected(res)
+}
+
+// Restore undoes a soft delete and returns the user, restoring one that isn't deleted is a no-op
+func (s *SQLiteUserStore) Restore(ctx context.Context, id string) (model.User, error) {
+	row := s.db.QueryRowContext(ctx,
+		`UPDATE users SET deleted_at = NULL, updated_at = CASE WHEN deleted_at IS NULL THEN updated_at ELSE ? END WHERE id = ? RETURNING `+userColumns,
+		time.Now().UTC(), id,
+	)
+	user, err := scanUserColumns(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.User{}, ErrUserNotFound
+		}
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// Stats counts the users that aren't deleted by verification and role in a single grouped query
+func (s *SQLiteUserStore) Stats(ctx context.Context) (StatsResult, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT role, verified, COUNT(*) FROM users WHERE deleted_at IS NULL GROUP BY role, verified`)
+	if err != nil {
+		return StatsResult{}, err
+	}
+	defer rows.Close()
+
+	stats := StatsResult{ByRole: map[string]int{}}
+	for rows.Next() {
+		var (
+			role     string
+			verified bool
+			count    int
+		)
+		if err := rows.Scan(&role, &verified, &count); err != nil {
+			return StatsResult{}, err
+		}
+		stats.Total += count
+		if verified {
+			stats.Verified += count
+		} else {
+			stats.Unverified += count
+		}
+		stats.ByRole[role] += count
+	}
+	return stats, rows.Err()
+}
+
+// Ping checks the database answers queries
+func (s *SQLiteUserStore) Ping(ctx context.Context) error {
+	var one int
+	return s.db.QueryRowContext(ctx, `SELECT 1`).Scan(&one)
+}
+
+// Run a query returning user rows
+func (s *SQLiteUserStore) queryUsers(ctx context.Context, query string, args ...interface{}) ([]model.User, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return []model.User{}, err
+	}
+	defer rows.Close()
+
+	users := []model.User{}
+	for rows.Next() {
+		user, err := scanUserColumns(rows)
+		if err != nil {
+			return []model.User{}, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// ORDER BY clause for a sort, the field is checked against the known columns
+// so it is safe to put into the query
+func orderBy(sort UserSort) string {
+	field := sort.Field
+	if !IsSortField(field) {
+		field = SortByName
+	}
+	dir := "ASC"
+	if sort.Desc {
+		dir = "DESC"
+	}
+	return field + " " + dir + ", id " + dir
+}
+
+// Scan a single user row, ErrUserNotFound when there is none
+func scanUser(row *sql.Row) (model.User, error) {
+	user, err := scanUserColumns(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return model.User{}, ErrUserNotFound
+	}
+	if err != nil {
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+// Either *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Scan the userColumns of a row
+func scanUserColumns(row rowScanner) (model.User, error) {
+	var (
+		user        model.User
+		deletedAt   sql.NullTime
+		lastLoginAt sql.NullTime
+	)
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.PasswordHash, &user.Verified, &user.Role, &user.CreatedAt, &user.UpdatedAt, &deletedAt, &lastLoginAt, &user.AvatarPath)
+	if deletedAt.Valid {
+		user.DeletedAt = &deletedAt.Time
+	}
+	if lastLoginAt.Valid {
+		user.LastLoginAt = &lastLoginAt.Time
+	}
+	return user, err
+}
+
+// Condition hiding soft-deleted users, joined to the query by prefix, or nothing when they are included
+func notDeleted(includeDeleted bool, prefix string) string {
+	if includeDeleted {
+		return ""
+	}
+	return prefix + "deleted_at IS NULL"
+}
+
+// Return ErrUserNotFound when a statement didn't touch any row
+func requireRowAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Report whether err is a UNIQUE constraint violation, i.e. a taken email
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
@@ -0,0 +1,252 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+// Open a SQLite store in a fresh temporary file, closed when the test ends
+func newTestSQLiteStore(t *testing.T) *SQLiteUserStore {
+	t.Helper()
+	s, err := NewSQLiteUserStore(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLiteCancelledContext(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	user, err := s.Create(context.Background(), model.User{Name: "Melisa", Email: "melisa@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.GetByID(ctx, user.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetByID error = %v, want context.Canceled", err)
+	}
+	if _, err := s.GetByEmail(ctx, user.Email); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetByEmail error = %v, want context.Canceled", err)
+	}
+	if _, err := s.List(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("List error = %v, want context.Canceled", err)
+	}
+	if _, _, err := s.ListPaged(ctx, DefaultUserSort, false, 0, 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListPaged error = %v, want context.Canceled", err)
+	}
+	if _, err := s.ListAfter(ctx, UserCursor{}, false, 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListAfter error = %v, want context.Canceled", err)
+	}
+	if _, _, err := s.Search(ctx, "mel", "", false, 0, 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("Search error = %v, want context.Canceled", err)
+	}
+	if _, err := s.Create(ctx, model.User{Name: "Ada", Email: "ada@example.com", PasswordHash: "hash"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Create error = %v, want context.Canceled", err)
+	}
+	if err := s.Delete(ctx, user.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("Delete error = %v, want context.Canceled", err)
+	}
+
+	// Nothing was changed by the cancelled calls
+	if _, err := s.GetByID(context.Background(), user.ID); err != nil {
+		t.Errorf("GetByID after cancelled Delete: %v", err)
+	}
+}
+
+func TestSQLiteGetMissingUser(t *testing.T) {
+	s := newTestSQLiteStore(t)
+
+	if _, err := s.GetByID(context.Background(), "missing"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetByID error = %v, want ErrUserNotFound", err)
+	}
+	if _, err := s.GetByEmail(context.Background(), "missing@example.com"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetByEmail error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestSQLiteEmailTakenBySoftDeletedUser(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	ctx := context.Background()
+	user, err := s.Create(ctx, model.User{Name: "Melisa", Email: "melisa@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SoftDelete(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetByEmail(ctx, "melisa@example.com"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetByEmail of a soft-deleted user = %v, want ErrUserNotFound", err)
+	}
+	if taken, err := s.EmailTaken(ctx, "Melisa@example.com"); err != nil || !taken {
+		t.Errorf("EmailTaken = %v, %v, want true", taken, err)
+	}
+	if taken, err := s.EmailTaken(ctx, "ada@example.com"); err != nil || taken {
+		t.Errorf("EmailTaken of a free email = %v, %v, want false", taken, err)
+	}
+}
+
+func TestSQLiteCreateAndGet(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	ctx := context.Background()
+
+	created, err := s.Create(ctx, model.User{Name: "Melisa", Email: "melisa@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.CreatedAt.IsZero() {
+		t.Errorf("Create didn't set the id and creation time: %+v", created)
+	}
+
+	byID, err := s.GetByID(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byEmail, err := s.GetByEmail(ctx, "melisa@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, got := range []model.User{byID, byEmail} {
+		if got.ID != created.ID || got.Name != "Melisa" || got.PasswordHash != "hash" || !got.CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("got %+v, want %+v", got, created)
+		}
+	}
+}
+
+func TestSQLiteDuplicateEmail(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	ctx := context.Background()
+	if _, err := s.Create(ctx, model.User{Name: "Melisa", Email: "melisa@example.com", PasswordHash: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := s.Create(ctx, model.User{Name: "Other", Email: "melisa@example.com", PasswordHash: "hash"})
+	if !errors.Is(err, ErrEmailExists) {
+		t.Errorf("Create with a taken email = %v, want ErrEmailExists", err)
+	}
+}
+
+func TestSQLiteKeepsUsersAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.db")
+	s, err := NewSQLiteUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := s.Create(context.Background(), model.User{Name: "Melisa", Email: "melisa@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = NewSQLiteUserStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.GetByID(context.Background(), created.ID); err != nil {
+		t.Errorf("user is gone after reopening: %v", err)
+	}
+}
+
+func TestSQLitePing(t *testing.T) {
+	s, err := NewSQLiteUserStore(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping = %v", err)
+	}
+	s.Close()
+	if err := s.Ping(context.Background()); err == nil {
+		t.Error("Ping of a closed store succeeded")
+	}
+}
+
+func TestSQLiteTimestamps(t *testing.T) {
+	testTimestamps(t, newTestSQLiteStore(t))
+}
+
+func TestSQLiteLastLogin(t *testing.T) {
+	testLastLogin(t, newTestSQLiteStore(t))
+}
+
+func TestSQLiteListAfter(t *testing.T) {
+	testListAfter(t, newTestSQLiteStore(t))
+}
+
+func TestSQLiteDeleteMany(t *testing.T) {
+	testDeleteMany(t, newTestSQLiteStore(t))
+}
+
+func TestSQLiteDeleteManyRollsBack(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	ctx := context.Background()
+	var ids []string
+	for _, name := range []string{"melisa", "zeynep"} {
+		user, err := s.Create(ctx, model.User{Name: name, Email: name + "@example.com", PasswordHash: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, user.ID)
+	}
+
+	// Fail the batch at its second delete
+	if _, err := s.db.ExecContext(ctx, `CREATE TRIGGER fail_delete BEFORE DELETE ON users WHEN OLD.id = '`+ids[1]+`' BEGIN SELECT RAISE(ABORT, 'refused'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DeleteMany(ctx, ids, false); err == nil {
+		t.Fatal("DeleteMany succeeded despite the failing delete")
+	}
+	if _, err := s.GetByID(ctx, ids[0]); err != nil {
+		t.Errorf("first user of the failed batch = %v, want it kept", err)
+	}
+}
+
+func TestSQLiteAuditLog(t *testing.T) {
+	testAuditLog(t, newTestSQLiteStore(t).AuditLog())
+}
+
+func TestSQLiteAuditLogListFailure(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	log := s.AuditLog()
+	if err := log.Append(context.Background(), AuditEntry{Actor: "anonymous", Action: AuditUserCreate, TargetID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Close()
+	if entries, total, err := log.List(context.Background(), "", "", 0, 10); err == nil {
+		t.Errorf("List of a closed database = %v, %d, want an error", entries, total)
+	}
+}
+
+func TestSQLiteListPagedSort(t *testing.T) {
+	testListPagedSort(t, newTestSQLiteStore(t))
+}
+
+func TestSQLiteStats(t *testing.T) {
+	testStats(t, newTestSQLiteStore(t))
+}
+
+func TestSQLiteLengthLimits(t *testing.T) {
+	s := newTestSQLiteStore(t)
+	ctx := context.Background()
+
+	if _, err := s.Create(ctx, model.User{Name: strings.Repeat("a", 101), Email: "melisa@example.com", PasswordHash: "hash"}); err == nil {
+		t.Error("stored a 101 character name")
+	}
+	if _, err := s.Create(ctx, model.User{Name: "Melisa", Email: strings.Repeat("a", 243) + "@example.com", PasswordHash: "hash"}); err == nil {
+		t.Error("stored a 255 character email")
+	}
+	if _, err := s.Create(ctx, model.User{Name: strings.Repeat("a", 100), Email: "melisa@example.com", PasswordHash: "hash"}); err != nil {
+		t.Errorf("100 character name: %v", err)
+	}
+}
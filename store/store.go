@@ -0,0 +1,533 @@
+// Package store persists users, in memory or in SQLite.
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	// https://pkg.go.dev/github.com/google/uuid
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+// Returned by Create and Update when another user already has the same email
+var ErrEmailExists = errors.New("email already exists")
+
+// Returned when no user has the given id. Soft-deleted users count as not found,
+// except by Restore.
+var ErrUserNotFound = errors.New("user not found")
+
+// Fields users can be sorted by
+const (
+	SortByName      = "name"
+	SortByEmail     = "email"
+	SortByCreatedAt = "created_at"
+)
+
+// UserSort orders a user listing by Field, descending when Desc is set.
+// Users with equal values are ordered by id so pages don't overlap.
+type UserSort struct {
+	Field string
+	Desc  bool
+}
+
+// Position in a listing ordered by creation time, then id. Listing after a cursor
+// returns the users created later, so rows inserted meanwhile don't shift
+// the pages like they do with offsets. The zero cursor starts at the beginning.
+type UserCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// Whether user comes after the cursor
+func (cur UserCursor) before(user model.User) bool {
+	if c := user.CreatedAt.Compare(cur.CreatedAt); c != 0 {
+		return c > 0
+	}
+	return user.ID > cur.ID
+}
+
+// Order used when the client doesn't ask for one
+var DefaultUserSort = UserSort{Field: SortByName}
+
+// Whether users can be sorted by field
+func IsSortField(field string) bool {
+	switch field {
+	case SortByName, SortByEmail, SortByCreatedAt:
+		return true
+	}
+	return false
+}
+
+// UserStore persists registered users. Every method takes the request context,
+// so a cancelled or timed out request stops its queries and returns ctx.Err().
+// Lookups return ErrUserNotFound for a missing user and any other error when
+// the store couldn't be read, so the two aren't confused.
+// Soft-deleted users are skipped unless includeDeleted is set, they keep their email.
+type UserStore interface {
+	Create(ctx context.Context, user model.User) (model.User, error)
+	GetByEmail(ctx context.Context, email string) (model.User, error)
+	GetByID(ctx context.Context, id string) (model.User, error)
+	EmailTaken(ctx context.Context, email string) (bool, error)
+	List(ctx context.Context) ([]model.User, error)
+	ListPaged(ctx context.Context, sort UserSort, includeDeleted bool, offset, limit int) ([]model.User, int, error)
+	ListAfter(ctx context.Context, after UserCursor, includeDeleted bool, limit int) ([]model.User, error)
+	Search(ctx context.Context, query, role string, includeDeleted bool, offset, limit int) ([]model.User, int, error)
+	Update(ctx context.Context, id string, user model.User) (model.User, error)
+	SetVerified(ctx context.Context, id string) error
+	UpdatePassword(ctx context.Context, id, passwordHash string) error
+	SetLastLogin(ctx context.Context, id string, at time.Time) error
+	SetAvatar(ctx context.Context, id, path string) error
+	Delete(ctx context.Context, id string) error
+	SoftDelete(ctx context.Context, id string) error
+	DeleteMany(ctx context.Context, ids []string, soft bool) ([]bool, error)
+	Restore(ctx context.Context, id string) (model.User, error)
+	Stats(ctx context.Context) (StatsResult, error)
+	Ping(ctx context.Context) error
+}
+
+// Aggregate counts over every user
+type StatsResult struct {
+	Total      int            `json:"total"`
+	Verified   int            `json:"verified"`
+	Unverified int            `json:"unverified"`
+	ByRole     map[string]int `json:"by_role"`
+}
+
+// MemoryUserStore keeps users in a map, so they are lost on restart
+type MemoryUserStore struct {
+	mu      sync.RWMutex
+	users   map[string]model.User // users by id
+	byEmail map[string]string     // user ids by email
+}
+
+// Create an empty in-memory store
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		users:   map[string]model.User{},
+		byEmail: map[string]string{},
+	}
+}
+
+// Create saves a new user under a fresh UUID and returns the stored user
+func (s *MemoryUserStore) Create(ctx context.Context, user model.User) (model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return model.User{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user.Email = NormalizeEmail(user.Email)
+	if _, ok := s.byEmail[user.Email]; ok {
+		return model.User{}, ErrEmailExists
+	}
+
+	user.ID = uuid.NewString()
+	if user.Role == "" {
+		user.Role = model.RoleUser
+	}
+	user.CreatedAt = time.Now().UTC()
+	user.UpdatedAt = user.CreatedAt
+	s.users[user.ID] = user
+	s.byEmail[user.Email] = user.ID
+	return user, nil
+}
+
+// GetByEmail finds a user by email
+func (s *MemoryUserStore) GetByEmail(ctx context.Context, email string) (model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return model.User{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.byEmail[NormalizeEmail(email)]
+	if !ok {
+		return model.User{}, ErrUserNotFound
+	}
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt != nil {
+		return model.User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// GetByID finds a user by id
+func (s *MemoryUserStore) GetByID(ctx context.Context, id string) (model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return model.User{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt != nil {
+		return model.User{}, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// EmailTaken reports whether Create would refuse the email, which soft-deleted users keep
+func (s *MemoryUserStore) EmailTaken(ctx context.Context, email string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.byEmail[NormalizeEmail(email)]
+	return ok, nil
+}
+
+// List returns every user that isn't deleted, in no particular order
+func (s *MemoryUserStore) List(ctx context.Context) ([]model.User, error) {
+	return s.list(ctx, false)
+}
+
+// Copy the users out of the map, with the soft-deleted ones if includeDeleted is set
+func (s *MemoryUserStore) list(ctx context.Context, includeDeleted bool) ([]model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]model.User, 0, len(s.users))
+	for _, user := range s.users {
+		if user.DeletedAt != nil && !includeDeleted {
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// ListPaged returns up to limit users in the given order, starting at offset,
+// along with the total number of users
+func (s *MemoryUserStore) ListPaged(ctx context.Context, sort UserSort, includeDeleted bool, offset, limit int) ([]model.User, int, error) {
+	users, err := s.list(ctx, includeDeleted)
+	if err != nil {
+		return nil, 0, err
+	}
+	page, total := paginate(users, sort, offset, limit)
+	return page, total, nil
+}
+
+// ListAfter returns up to limit users created after the cursor, oldest first
+func (s *MemoryUserStore) ListAfter(ctx context.Context, after UserCursor, includeDeleted bool, limit int) ([]model.User, error) {
+	all, err := s.list(ctx, includeDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []model.User
+	for _, user := range all {
+		if after.before(user) {
+			users = append(users, user)
+		}
+	}
+	page, _ := paginate(users, UserSort{Field: SortByCreatedAt}, 0, limit)
+	return page, nil
+}
+
+// Search pages through the users whose name or email contains query, ignoring case,
+// and whose role is role. An empty query or role matches every user.
+func (s *MemoryUserStore) Search(ctx context.Context, query, role string, includeDeleted bool, offset, limit int) ([]model.User, int, error) {
+	all, err := s.list(ctx, includeDeleted)
+	if err != nil {
+		return nil, 0, err
+	}
+	query = strings.ToLower(query)
+
+	var matches []model.User
+	for _, user := range all {
+		if role != "" && user.Role != role {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(user.Name), query) && !strings.Contains(user.Email, query) {
+			continue
+		}
+		matches = append(matches, user)
+	}
+	page, total := paginate(matches, DefaultUserSort, offset, limit)
+	return page, total, nil
+}
+
+// Sort users and cut out one page, along with the total number of users
+func paginate(users []model.User, order UserSort, offset, limit int) ([]model.User, int) {
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if order.Desc {
+			a, b = b, a
+		}
+
+		var c int
+		switch order.Field {
+		case SortByEmail:
+			c = strings.Compare(a.Email, b.Email)
+		case SortByCreatedAt:
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		default:
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if c == 0 {
+			return a.ID < b.ID
+		}
+		return c < 0
+	})
+
+	total := len(users)
+	if offset >= total {
+		return []model.User{}, total
+	}
+	end := min(offset+limit, total)
+	return users[offset:end], total
+}
+
+// Update replaces the name and email of an existing user and returns the stored user,
+// the password is left unchanged
+func (s *MemoryUserStore) Update(ctx context.Context, id string, user model.User) (model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return model.User{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.users[id]
+	if !ok || current.DeletedAt != nil {
+		return model.User{}, ErrUserNotFound
+	}
+
+	email := NormalizeEmail(user.Email)
+	if otherID, ok := s.byEmail[email]; ok && otherID != id {
+		return model.User{}, ErrEmailExists
+	}
+
+	delete(s.byEmail, current.Email)
+	current.Name = user.Name
+	current.Email = email
+	current.UpdatedAt = time.Now().UTC()
+	s.users[id] = current
+	s.byEmail[email] = id
+	return current, nil
+}
+
+// SetVerified marks a user's email as verified
+func (s *MemoryUserStore) SetVerified(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt != nil {
+		return ErrUserNotFound
+	}
+
+	user.Verified = true
+	user.UpdatedAt = time.Now().UTC()
+	s.users[id] = user
+	return nil
+}
+
+// UpdatePassword replaces a user's password hash
+func (s *MemoryUserStore) UpdatePassword(ctx context.Context, id, passwordHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt != nil {
+		return ErrUserNotFound
+	}
+
+	user.PasswordHash = passwordHash
+	user.UpdatedAt = time.Now().UTC()
+	s.users[id] = user
+	return nil
+}
+
+// SetLastLogin records when a user last logged in, it isn't counted as a change to UpdatedAt
+func (s *MemoryUserStore) SetLastLogin(ctx context.Context, id string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt != nil {
+		return ErrUserNotFound
+	}
+
+	at = at.UTC()
+	user.LastLoginAt = &at
+	s.users[id] = user
+	return nil
+}
+
+// SetAvatar records the file holding a user's profile image
+func (s *MemoryUserStore) SetAvatar(ctx context.Context, id, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt != nil {
+		return ErrUserNotFound
+	}
+
+	user.AvatarPath = path
+	user.UpdatedAt = time.Now().UTC()
+	s.users[id] = user
+	return nil
+}
+
+// Delete removes a user
+func (s *MemoryUserStore) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	delete(s.users, id)
+	delete(s.byEmail, user.Email)
+	return nil
+}
+
+// DeleteMany deletes, or with soft only marks deleted, every user in ids at once.
+// It reports for each id whether that user existed.
+func (s *MemoryUserStore) DeleteMany(ctx context.Context, ids []string, soft bool) ([]bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	deleted := make([]bool, len(ids))
+	for i, id := range ids {
+		user, ok := s.users[id]
+		switch {
+		case !ok:
+			continue
+		case !soft:
+			delete(s.users, id)
+			delete(s.byEmail, user.Email)
+		case user.DeletedAt != nil:
+			continue
+		default:
+			user.DeletedAt = &now
+			user.UpdatedAt = now
+			s.users[id] = user
+		}
+		deleted[i] = true
+	}
+	return deleted, nil
+}
+
+// SoftDelete marks a user deleted, hiding them until Restore
+func (s *MemoryUserStore) SoftDelete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt != nil {
+		return ErrUserNotFound
+	}
+
+	now := time.Now().UTC()
+	user.DeletedAt = &now
+	user.UpdatedAt = now
+	s.users[id] = user
+	return nil
+}
+
+// Restore undoes a soft delete and returns the user, restoring one that isn't deleted is a no-op
+func (s *MemoryUserStore) Restore(ctx context.Context, id string) (model.User, error) {
+	if err := ctx.Err(); err != nil {
+		return model.User{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return model.User{}, ErrUserNotFound
+	}
+
+	if user.DeletedAt != nil {
+		user.DeletedAt = nil
+		user.UpdatedAt = time.Now().UTC()
+		s.users[id] = user
+	}
+	return user, nil
+}
+
+// Stats counts the users that aren't deleted by verification and role
+func (s *MemoryUserStore) Stats(ctx context.Context) (StatsResult, error) {
+	if err := ctx.Err(); err != nil {
+		return StatsResult{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := StatsResult{ByRole: map[string]int{}}
+	for _, user := range s.users {
+		if user.DeletedAt != nil {
+			continue
+		}
+		stats.Total++
+		if user.Verified {
+			stats.Verified++
+		} else {
+			stats.Unverified++
+		}
+		stats.ByRole[user.Role]++
+	}
+	return stats, nil
+}
+
+// Ping only fails once ctx is done, the map is always available
+func (s *MemoryUserStore) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Emails are compared case-insensitively, so they are stored in lowercase
+func NormalizeEmail(email string) string {
+	return strings.ToLower(email)
+}
@@ -0,0 +1,275 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/melisacar/go-rest-api.git/model"
+)
+
+func TestMemoryCancelledContext(t *testing.T) {
+	s := NewMemoryUserStore()
+	user, err := s.Create(context.Background(), model.User{Name: "Melisa", Email: "melisa@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.GetByID(ctx, user.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetByID error = %v, want context.Canceled", err)
+	}
+	if _, _, err := s.ListPaged(ctx, DefaultUserSort, false, 0, 10); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListPaged error = %v, want context.Canceled", err)
+	}
+	if _, err := s.GetByID(context.Background(), "missing"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetByID of a missing user = %v, want ErrUserNotFound", err)
+	}
+}
+
+// Check Create sets both timestamps and Update only bumps UpdatedAt
+func testTimestamps(t *testing.T, s UserStore) {
+	t.Helper()
+	ctx := context.Background()
+	created, err := s.Create(ctx, model.User{Name: "Melisa", Email: "melisa@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.CreatedAt.IsZero() || !created.UpdatedAt.Equal(created.CreatedAt) {
+		t.Errorf("created_at %v updated_at %v, want both set and equal", created.CreatedAt, created.UpdatedAt)
+	}
+
+	time.Sleep(time.Millisecond)
+	created.Name = "Melisa Acar"
+	updated, err := s.Update(ctx, created.ID, created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.GetByID(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, got := range []model.User{updated, stored} {
+		if !got.CreatedAt.Equal(created.CreatedAt) {
+			t.Errorf("created_at = %v after an update, want %v", got.CreatedAt, created.CreatedAt)
+		}
+		if !got.UpdatedAt.After(created.UpdatedAt) {
+			t.Errorf("updated_at = %v after an update, want later than %v", got.UpdatedAt, created.UpdatedAt)
+		}
+	}
+}
+
+func TestMemoryTimestamps(t *testing.T) {
+	testTimestamps(t, NewMemoryUserStore())
+}
+
+// Check SetLastLogin is kept without counting as a change to UpdatedAt
+func testLastLogin(t *testing.T, s UserStore) {
+	t.Helper()
+	ctx := context.Background()
+	created, err := s.Create(ctx, model.User{Name: "Melisa", Email: "melisa@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.LastLoginAt != nil {
+		t.Errorf("new user has last_login_at %v", created.LastLoginAt)
+	}
+
+	at := time.Now().Add(time.Minute).Truncate(time.Second)
+	if err := s.SetLastLogin(ctx, created.ID, at); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.GetByID(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.LastLoginAt == nil || !stored.LastLoginAt.Equal(at) {
+		t.Errorf("last_login_at = %v, want %v", stored.LastLoginAt, at)
+	}
+	if !stored.UpdatedAt.Equal(created.UpdatedAt) {
+		t.Errorf("updated_at = %v after a login, want %v", stored.UpdatedAt, created.UpdatedAt)
+	}
+	if err := s.SetLastLogin(ctx, "missing", at); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("SetLastLogin of a missing user = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestMemoryLastLogin(t *testing.T) {
+	testLastLogin(t, NewMemoryUserStore())
+}
+
+// Check ListAfter walks users oldest first, picking up users created mid-walk
+func testListAfter(t *testing.T, s UserStore) {
+	t.Helper()
+	ctx := context.Background()
+	var ids []string
+	for _, name := range []string{"melisa", "zeynep", "ada"} {
+		user, err := s.Create(ctx, model.User{Name: name, Email: name + "@example.com", PasswordHash: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, user.ID)
+		time.Sleep(time.Millisecond)
+	}
+
+	first, err := s.ListAfter(ctx, UserCursor{}, false, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	late, err := s.Create(ctx, model.User{Name: "sena", Email: "sena@example.com", PasswordHash: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := first[len(first)-1]
+	rest, err := s.ListAfter(ctx, UserCursor{CreatedAt: last.CreatedAt, ID: last.ID}, false, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, user := range append(first, rest...) {
+		got = append(got, user.ID)
+	}
+	if want := append(ids, late.ID); !slices.Equal(got, want) {
+		t.Errorf("ids = %v, want %v", got, want)
+	}
+}
+
+func TestMemoryListAfter(t *testing.T) {
+	testListAfter(t, NewMemoryUserStore())
+}
+
+// Check DeleteMany reports which ids existed, deleting or soft deleting only those
+func testDeleteMany(t *testing.T, s UserStore) {
+	t.Helper()
+	ctx := context.Background()
+	var ids []string
+	for _, name := range []string{"melisa", "zeynep", "ada"} {
+		user, err := s.Create(ctx, model.User{Name: name, Email: name + "@example.com", PasswordHash: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, user.ID)
+	}
+
+	deleted, err := s.DeleteMany(ctx, []string{ids[0], "missing", ids[0]}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{true, false, false}; !slices.Equal(deleted, want) {
+		t.Errorf("hard delete = %v, want %v", deleted, want)
+	}
+	if _, err := s.GetByID(ctx, ids[0]); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("deleted user = %v, want ErrUserNotFound", err)
+	}
+
+	// Soft deleting twice only counts the first time
+	deleted, err = s.DeleteMany(ctx, []string{ids[1], ids[1]}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []bool{true, false}; !slices.Equal(deleted, want) {
+		t.Errorf("soft delete = %v, want %v", deleted, want)
+	}
+	if taken, _ := s.EmailTaken(ctx, "zeynep@example.com"); !taken {
+		t.Error("soft-deleted user lost their email")
+	}
+	if _, err := s.GetByID(ctx, ids[2]); err != nil {
+		t.Errorf("user outside the batch = %v", err)
+	}
+}
+
+func TestMemoryDeleteMany(t *testing.T) {
+	testDeleteMany(t, NewMemoryUserStore())
+}
+
+// Check ListPaged orders by every sort field in both directions
+func testListPagedSort(t *testing.T, s UserStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	// Created in this order, so creation time differs from name and email order
+	for _, u := range []struct{ name, email string }{
+		{"Bora", "c@example.com"},
+		{"Ada", "b@example.com"},
+		{"Cem", "a@example.com"},
+	} {
+		if _, err := s.Create(ctx, model.User{Name: u.name, Email: u.email, PasswordHash: "hash"}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	tests := []struct {
+		sort UserSort
+		want []string
+	}{
+		{UserSort{Field: SortByName}, []string{"Ada", "Bora", "Cem"}},
+		{UserSort{Field: SortByName, Desc: true}, []string{"Cem", "Bora", "Ada"}},
+		{UserSort{Field: SortByEmail}, []string{"Cem", "Ada", "Bora"}},
+		{UserSort{Field: SortByEmail, Desc: true}, []string{"Bora", "Ada", "Cem"}},
+		{UserSort{Field: SortByCreatedAt}, []string{"Bora", "Ada", "Cem"}},
+		{UserSort{Field: SortByCreatedAt, Desc: true}, []string{"Cem", "Ada", "Bora"}},
+	}
+	for _, tt := range tests {
+		users, total, err := s.ListPaged(ctx, tt.sort, false, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, user := range users {
+			names = append(names, user.Name)
+		}
+		if total != 3 || !slices.Equal(names, tt.want) {
+			t.Errorf("%+v: got %v of %d, want %v", tt.sort, names, total, tt.want)
+		}
+	}
+}
+
+func TestMemoryListPagedSort(t *testing.T) {
+	testListPagedSort(t, NewMemoryUserStore())
+}
+
+// Check Stats counts verification and roles, leaving out soft-deleted users
+func testStats(t *testing.T, s UserStore) {
+	t.Helper()
+	ctx := context.Background()
+	var ids []string
+	for _, u := range []model.User{
+		{Name: "Admin", Email: "admin@example.com", Role: model.RoleAdmin, Verified: true},
+		{Name: "Melisa", Email: "melisa@example.com", Role: model.RoleUser},
+		{Name: "Ada", Email: "ada@example.com", Role: model.RoleUser},
+		{Name: "Gone", Email: "gone@example.com", Role: model.RoleUser},
+	} {
+		u.PasswordHash = "hash"
+		created, err := s.Create(ctx, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, created.ID)
+	}
+	if err := s.SetVerified(ctx, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SoftDelete(ctx, ids[3]); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := s.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := StatsResult{Total: 3, Verified: 2, Unverified: 1, ByRole: map[string]int{model.RoleAdmin: 1, model.RoleUser: 2}}
+	if stats.Total != want.Total || stats.Verified != want.Verified || stats.Unverified != want.Unverified || !maps.Equal(stats.ByRole, want.ByRole) {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestMemoryStats(t *testing.T) {
+	testStats(t, NewMemoryUserStore())
+}